@@ -16,16 +16,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"path/filepath"
 
+	"github.com/agajdosi/artificial_suspects/backend/llm"
 	"github.com/google/uuid"
-	"github.com/sashabaranov/go-openai"
 )
 
 // MARK: ROUTERS - GET
 
 // Get the prefilled Descriptions generated by Service's LLM Model of the Subject from the database.
-// They are stored in the database to save time and money of the users.
+// They are stored in the database to save time and money of the users. Rejected Descriptions are left out.
 func GetDescriptionsForSuspect(suspectUUID, modelName string, strict bool) ([]Description, error) {
 	var descriptions []Description
 	service, err := GetServiceForModel(modelName)
@@ -33,7 +32,8 @@ func GetDescriptionsForSuspect(suspectUUID, modelName string, strict bool) ([]De
 		return nil, err
 	}
 
-	query := "SELECT UUID, Description, Prompt, Timestamp FROM descriptions WHERE SuspectUUID = $1 AND Service = $2 AND Model = $3"
+	query := `SELECT UUID, ReportedModel, Description, Prompt, PromptUUID, Temperature, TopP, Seed, MaxTokens, Timestamp FROM descriptions
+		WHERE SuspectUUID = $1 AND Service = $2 AND Model = $3 AND Rejected = 0`
 	rows, err := database.Query(query, suspectUUID, service.Name, modelName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get descriptions: %w", err)
@@ -46,7 +46,8 @@ func GetDescriptionsForSuspect(suspectUUID, modelName string, strict bool) ([]De
 			Service:     service.Name,
 			Model:       modelName,
 		}
-		err := rows.Scan(&d.UUID, &d.Description, &d.Prompt, &d.Timestamp)
+		err := rows.Scan(&d.UUID, &d.ReportedModel, &d.Description, &d.Prompt, &d.PromptUUID,
+			&d.Sampling.Temperature, &d.Sampling.TopP, &d.Sampling.Seed, &d.Sampling.MaxTokens, &d.Timestamp)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan description row: %w", err)
 		}
@@ -72,7 +73,8 @@ func GetDescriptionsForSuspect(suspectUUID, modelName string, strict bool) ([]De
 // because there are not any pre-generated descriptions by requested model in the database.
 func GetAnyDescriptionsForSuspect(suspectUUID string) ([]Description, error) {
 	var descriptions []Description
-	query := "SELECT UUID, Description, Service, Model, Prompt, Timestamp FROM descriptions WHERE SuspectUUID = $1"
+	query := `SELECT UUID, Description, Service, Model, ReportedModel, Prompt, PromptUUID, Temperature, TopP, Seed, MaxTokens, Timestamp FROM descriptions
+		WHERE SuspectUUID = $1 AND Rejected = 0`
 	rows, err := database.Query(query, suspectUUID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get descriptions: %w", err)
@@ -81,7 +83,8 @@ func GetAnyDescriptionsForSuspect(suspectUUID string) ([]Description, error) {
 
 	for rows.Next() {
 		var d = Description{SuspectUUID: suspectUUID}
-		err := rows.Scan(&d.UUID, &d.Description, &d.Service, &d.Model, &d.Prompt, &d.Timestamp)
+		err := rows.Scan(&d.UUID, &d.Description, &d.Service, &d.Model, &d.ReportedModel, &d.Prompt, &d.PromptUUID,
+			&d.Sampling.Temperature, &d.Sampling.TopP, &d.Sampling.Seed, &d.Sampling.MaxTokens, &d.Timestamp)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan description row: %w", err)
 		}
@@ -102,15 +105,20 @@ func GetAnyDescriptionsForSuspect(suspectUUID string) ([]Description, error) {
 // MARK: ROUTER-GENERATE
 
 // Generate description of the Suspect's portrait.
+// Generated text is checked by ValidateDescription(). Rejected one is saved marked as rejected
+// and the model is asked again, up to DescriptionMaxAttempts times.
 func GenerateDescription(suspectUUID, modelName string) error {
 	service, err := GetServiceForModel(modelName)
 	if err != nil {
 		return err
 	}
 	fmt.Printf("Generating description using model %s on service %s\n", modelName, service.Name)
-	if service.Token == "" {
+	if service.Token.IsEmpty() && !service.llmService().IsLocal() {
 		return fmt.Errorf("token for service %s not set", service.Name)
 	}
+	if _, err := llm.Get(service.llmService()); err != nil {
+		return err
+	}
 
 	suspect, err := GetSuspect(suspectUUID)
 	if err != nil {
@@ -118,76 +126,62 @@ func GenerateDescription(suspectUUID, modelName string) error {
 	}
 	fmt.Println("Generating description for suspect:", suspect)
 
-	imgPath := filepath.Join("..", "front", "static", "suspects", suspect.Image)
-	text, prompt, err := DescribeImage(imgPath, modelName, service)
-	if err != nil {
-		return err
-	}
+	imgPath := suspectImagePath(suspect)
+	for attempt := 1; ; attempt++ {
+		description, err := DescribeImage(imgPath, modelName, service)
+		if err != nil {
+			return err
+		}
 
-	fmt.Printf("Generated description: %s\n\nPrompt used: %s\n\n", text, prompt)
-	description := Description{
-		UUID:        uuid.New().String(),
-		SuspectUUID: suspectUUID,
-		Service:     service.Name,
-		Model:       modelName,
-		Description: text,
-		Prompt:      prompt,
-		Timestamp:   TimestampNow(),
-	}
+		fmt.Printf("Generated description (reported model %s): %s\n\nPrompt used: %s\n\n", description.ReportedModel, description.Description, description.Prompt)
+		description.UUID = uuid.New().String()
+		description.SuspectUUID = suspectUUID
+		description.Service = service.Name
+		description.Timestamp = TimestampNow()
+		recordUsage(UsageDescribe, service.Name, modelName, description.UUID, description.Usage)
+
+		validationErr := ValidateDescription(description.Description)
+		var rejection *RejectionError
+		if errors.As(validationErr, &rejection) {
+			description.Rejected = true
+			description.RejectionReason = rejection.Reason
+		}
 
-	fmt.Printf("--- Saving description: %s\n", description.Description)
+		fmt.Printf("--- Saving description: %s\n", description.Description)
+		if err := SaveDescription(description); err != nil {
+			return err
+		}
+		if validationErr == nil {
+			return nil
+		}
 
-	err = SaveDescription(description)
-	return err
+		log.Printf("⚠️  Description of suspect %s by model %s rejected (attempt %d/%d): %v\n", suspectUUID, modelName, attempt, DescriptionMaxAttempts, validationErr)
+		if attempt >= DescriptionMaxAttempts {
+			return fmt.Errorf("all %d descriptions of suspect %s by model %s were rejected: %w", attempt, suspectUUID, modelName, validationErr)
+		}
+	}
 }
 
-// Generate descriptions by Model for all suspects in the database.
-// Used by dev.go to populate the database with descriptions of all suspects by defined model.
+// Generate descriptions by Model for all suspects in the database, until each has limit of them.
+// Goes through the describe job queue, see jobs.go. dev.go uses the queue directly to show the progress.
 func GenerateDescriptionsForAllSuspects(modelName string, limit int) error {
-	suspects, err := GetAllSuspects()
+	if _, err := EnqueueDescribeJobs(modelName, limit); err != nil {
+		return err
+	}
+	progress, err := RunDescribeJobs(context.Background(), modelName, DefaultServiceConcurrency, nil)
 	if err != nil {
 		return err
 	}
-
-	for _, suspect := range suspects {
-		descriptions, err := GetDescriptionsForSuspect(suspect.UUID, modelName, true) // strictly get only descriptions for this model
-		if err != nil {
-			log.Printf("Error checking existing descriptions for suspect %s: %v", suspect.UUID, err)
-			continue
-		}
-
-		if len(descriptions) >= limit {
-			log.Printf("Skipping suspect %s: already has %d descriptions for model %s", suspect.UUID, len(descriptions), modelName)
-			continue
-		}
-
-		err = GenerateDescription(suspect.UUID, modelName)
-		if err != nil {
-			log.Printf("Error generating description for suspect %s: %v", suspect.UUID, err)
-		} else {
-			log.Printf("Successfully generated description for suspect %s", suspect.UUID)
-		}
+	if progress.Failed > 0 {
+		return fmt.Errorf("%d of %d describe jobs failed", progress.Failed, progress.Total)
 	}
 	return nil
 }
 
-// MARK: OPENAI
+// MARK: PROMPTS
+// Default versions of the prompts, saved into prompts table on the first start, see prompt.go.
 
-// Describe the image using the specified model.
-// Models must be one of visualModels.
-//
-// Returns description, prompt used and error.
-func DescribeImage(imagePath string, model string, service Service) (string, string, error) {
-	if service.Token == "" {
-		return "", "", errors.New("token cannot be empty")
-	}
-
-	imgBase64String, err := ImageToBase64(imagePath)
-	if err != nil {
-		return "", "", errors.New("failed to convert image to base64: " + err.Error())
-	}
-
-	prompt := `CONTEXT:
+const describePrompt = `CONTEXT:
 We are creating descriptive and interpretive material for later review by a testing group.
 
 ROLE:
@@ -211,105 +205,243 @@ Do NOT include any disclaimers such as:
 Avoid all self-referential or refusal statements. Focus entirely on creative interpretation and description.
 
 `
-	config := openai.DefaultConfig(service.Token)
-	if service.URL.String != "" {
-		config.BaseURL = service.URL.String
-	}
-	client := openai.NewClientWithConfig(config)
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: openai.GPT4o20240806,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-				{
-					Role: openai.ChatMessageRoleUser,
-					MultiContent: []openai.ChatMessagePart{
-						{
-							Type: openai.ChatMessagePartTypeImageURL,
-							ImageURL: &openai.ChatMessageImageURL{
-								URL:    fmt.Sprintf("data:image/jpeg;base64,%s", imgBase64String),
-								Detail: openai.ImageURLDetailHigh,
-							},
-						},
-					},
-				},
-			},
-		},
-	)
+
+const answerReflection = `ROLE: You are a player of Unusual Suspects board game - text based version. You are a witness.
+TASK: Read the description of the perpetrator and the question the police officer asked you about perpetrator.
+Write a short reflection on the perpetrator in relation to the question.
+Try to think both ways, both about the positive answer and the negative one, which one you lean more towards. Cca 100 words.
+QUESTION: {{.Question}}
+DESCRIPTION OF PERPETRATOR: {{.Description}}`
+
+const answerVisionReflection = `ROLE: You are a player of Unusual Suspects board game - text based version. You are a witness.
+TASK: Look at the attached photo of the perpetrator and read the question the police officer asked you about perpetrator.
+Write a short reflection on the perpetrator in relation to the question.
+Try to think both ways, both about the positive answer and the negative one, which one you lean more towards. Cca 100 words.
+QUESTION: {{.Question}}`
+
+const answerBoolean = `ROLE: You are a senior decision maker.
+TASK: Answer the question YES or NO. Do not write anything else. Do not write anything else. Just write YES, or NO based on the previous information.`
+
+// MARK: PROVIDERS
+
+// Describe the image using the specified model.
+// Model must have Model.Visual set, others are rejected before any request is made.
+// The Provider is chosen by Service.API_style, see package llm.
+//
+// Uses the active version of PromptDescribe and the sampling parameters of the Model.
+// Returns Description with Model, ReportedModel, Description, Prompt, PromptUUID and Sampling filled in,
+// the caller sets the rest.
+func DescribeImage(imagePath string, modelName string, service Service) (Description, error) {
+	var description Description
+	model, err := GetModel(modelName)
+	if err != nil {
+		return description, err
+	}
+	if !model.Visual {
+		return description, fmt.Errorf("model %s is not visual, cannot describe images", modelName)
+	}
+	if err := CheckBudget(model); err != nil {
+		return description, err
+	}
+
+	if service.Token.IsEmpty() && !service.llmService().IsLocal() {
+		return description, errors.New("token cannot be empty")
+	}
+
+	provider, err := llm.Get(service.llmService())
+	if err != nil {
+		return description, err
+	}
+
+	prompt, err := GetActivePrompt(PromptDescribe)
+	if err != nil {
+		return description, err
+	}
+	promptText, err := prompt.Render(nil)
 	if err != nil {
-		return "", "", err
+		return description, err
 	}
 
-	return resp.Choices[0].Message.Content, prompt, nil
+	imgBase64String, err := ImageToBase64(imagePath)
+	if err != nil {
+		return description, errors.New("failed to convert image to base64: " + err.Error())
+	}
+
+	completion, err := provider.Describe(context.Background(), service.llmService(), llm.DescribeRequest{
+		Model:       modelName,
+		Prompt:      promptText,
+		ImageBase64: imgBase64String,
+		Sampling:    model.Sampling,
+	})
+	if err != nil {
+		return description, err
+	}
+
+	description.Model = modelName
+	description.ReportedModel = completion.Model
+	description.Description = completion.Text
+	description.Prompt = promptText
+	description.PromptUUID = prompt.UUID
+	description.Sampling = model.Sampling
+	description.Usage = newUsage(completion.Usage, model)
+	return description, nil
 }
 
 // Generate answer to the question, based on the description of the suspect.
-// If Service defines non-empty URL, it is used as BaseURL for the OpenAI client - this allows usage of LLM proxies
-// or other services compatible with OpenAI styled API.
-// TODO: Add option to switch service (OpenAI, Anthropic, etc.)
-func GenerateAnswer(question, description, model string, service Service) (string, error) {
-	config := openai.DefaultConfig(service.Token)
-	if service.URL.String != "" {
-		config.BaseURL = service.URL.String
-	}
-	client := openai.NewClientWithConfig(config)
-	const answerReflection = `ROLE: You are a player of Unusual Suspects board game - text based version. You are a witness.
-TASK: Read the description of the perpetrator and the question the police officer asked you about perpetrator.
-Write a short reflection on the perpetrator in relation to the question.
-Try to think both ways, both about the positive answer and the negative one, which one you lean more towards. Cca 100 words.
-QUESTION: %s
-DESCRIPTION OF PERPETRATOR: %s`
-	reflectionPrompt := fmt.Sprintf(answerReflection, question, description)
-	reflectionResp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: reflectionPrompt,
-				},
-			},
-		},
-	)
+// Answer is generated in two steps: first the model writes a short reflection on the question,
+// then it decides YES or NO based on its own reflection. Active versions of PromptAnswerReflection
+// and PromptAnswerDecision are used. Structured output is requested where
+// the Provider supports it, raw decision text is then normalized by ParseDecision.
+// The Provider is chosen by Service.API_style, see package llm.
+//
+// Requests are sent with the sampling parameters of the model, which are recorded on the Answer.
+// If the answer cache policy allows, already generated Answer for the same question, description, model,
+// sampling parameters and prompt versions is reused instead, see cache.go.
+// With more answer samples set, the model is asked multiple times and the majority Decision wins, see voting.go.
+//
+// Returned Answer is not saved, set Answer.RoundUUID and pass it to SaveAnswer().
+func GenerateAnswer(question Question, description Description, model string, service Service) (Answer, error) {
+	return GenerateAnswerStream(question, description, model, service, nil)
+}
+
+// Same as GenerateAnswer(), but onToken is called with the pieces of the reflection as they arrive.
+// With more answer samples only the first sample is streamed. Cached reflection is sent at once.
+func GenerateAnswerStream(question Question, description Description, model string, service Service, onToken func(string)) (Answer, error) {
+	answer := newAnswer(question, model, service)
+	answer.DescriptionUUID = description.UUID
+	data := reflectionPromptData{Question: question.English, Description: description.Description}
+	return generateAnswer(answer, service, PromptAnswerReflection, data, "", onToken)
+}
+
+// Generate answer in vision witness mode: the visual model gets the portrait of the Suspect together
+// with the question instead of its Description, active version of PromptAnswerVisionReflection is used.
+// Otherwise it works as GenerateAnswer().
+func GenerateVisionAnswer(question Question, suspect Suspect, model string, service Service) (Answer, error) {
+	return GenerateVisionAnswerStream(question, suspect, model, service, nil)
+}
+
+// Same as GenerateVisionAnswer(), but onToken is called with the pieces of the reflection as they arrive.
+func GenerateVisionAnswerStream(question Question, suspect Suspect, model string, service Service, onToken func(string)) (Answer, error) {
+	answer := newAnswer(question, model, service)
+	answer.WitnessMode = WitnessVision
+	answer.SuspectUUID = suspect.UUID
+	image, err := ImageToBase64(suspectImagePath(suspect))
+	if err != nil {
+		return answer, fmt.Errorf("failed to convert image of suspect %s to base64: %w", suspect.UUID, err)
+	}
+	return generateAnswer(answer, service, PromptAnswerVisionReflection, reflectionPromptData{Question: question.English}, image, onToken)
+}
+
+func newAnswer(question Question, model string, service Service) Answer {
+	return Answer{
+		UUID:           uuid.New().String(),
+		QuestionUUID:   question.UUID,
+		Service:        service.Name,
+		Model:          model,
+		WitnessMode:    WitnessDescription,
+		Samples:        getAnswerSamples(),
+		StartTimestamp: TimestampNow(),
+	}
+}
+
+// Render the prompts and generate the answer, or reuse the cached one. imageBase64 is sent only in vision mode.
+func generateAnswer(answer Answer, service Service, reflectionPromptName string, data reflectionPromptData, imageBase64 string, onToken func(string)) (Answer, error) {
+	model := answer.Model
+	modelInfo, err := GetModel(model)
+	if err != nil {
+		return answer, err
+	}
+	if err := checkWitnessModel(modelInfo, answer.WitnessMode); err != nil {
+		return answer, err
+	}
+	answer.Sampling = modelInfo.Sampling
+
+	reflectionPrompt, err := GetActivePrompt(reflectionPromptName)
+	if err != nil {
+		return answer, err
+	}
+	answer.ReflectionPromptUUID = reflectionPrompt.UUID
+	answer.ReflectionPrompt, err = reflectionPrompt.Render(data)
+	if err != nil {
+		return answer, err
+	}
+
+	decisionPrompt, err := GetActivePrompt(PromptAnswerDecision)
+	if err != nil {
+		return answer, err
+	}
+	answer.DecisionPromptUUID = decisionPrompt.UUID
+	answer.DecisionPrompt, err = decisionPrompt.Render(nil)
+	if err != nil {
+		return answer, err
+	}
+
+	if cached, ok := cachedAnswer(answer); ok {
+		if onToken != nil {
+			onToken(cached.Reflection)
+		}
+		return cached, nil
+	}
+
+	provider, err := llm.Get(service.llmService())
 	if err != nil {
 		log.Printf("Error generating answer: %v\n", err)
-		return "", err
+		return answer, err
 	}
-	reflection := reflectionResp.Choices[0].Message.Content
-	log.Printf("AI sent reflection: %s\n", reflection)
 
-	const answerBoolean = `ROLE: You are a senior decision maker.
-TASK: Answer the question YES or NO. Do not write anything else. Do not write anything else. Just write YES, or NO based on the previous information.`
-	decisionResp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: reflectionPrompt,
-				},
-				{
-					Role:    openai.ChatMessageRoleAssistant,
-					Content: reflection,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: answerBoolean,
-				},
-			},
-		},
-	)
+	if err := CheckBudget(modelInfo); err != nil {
+		log.Printf("Error generating answer: %v\n", err)
+		return answer, err
+	}
+
+	request := llm.AnswerRequest{
+		Model:              model,
+		ReflectionPrompt:   answer.ReflectionPrompt,
+		DecisionPrompt:     answer.DecisionPrompt,
+		ImageBase64:        imageBase64,
+		Sampling:           answer.Sampling,
+		StructuredDecision: true,
+		OnReflectionToken:  onToken,
+	}
+	completion, votes, usage, err := sampleAnswers(context.Background(), provider, service.llmService(), request, answer.Samples)
+	answer.Usage = newUsage(usage, modelInfo)
+	if err == nil || usage != (llm.Usage{}) { // failed samples can be billed too
+		recordUsage(UsageAnswer, service.Name, model, answer.UUID, answer.Usage)
+	}
 	if err != nil {
 		log.Printf("Error generating answer: %v\n", err)
-		return "", err
+		return answer, err
+	}
+
+	answer.Reflection = completion.Reflection
+	answer.RawDecision = completion.Decision
+	answer.Votes = votes
+	answer.Decision = votes.Majority(ParseDecision(completion.Decision))
+	answer.Text = string(answer.Decision)
+	answer.Timestamp = TimestampNow()
+	if !answer.Decision.Valid() {
+		log.Printf("⚠️  Could not parse decision of model %s: %q\n", model, completion.Decision)
+	}
+	return answer, nil
+}
+
+// Returned by DescribeImage() and GenerateAnswer() when the Service failed too many times recently
+// and the circuit breaker does not let more calls through for a while.
+var ErrServiceUnavailable = llm.ErrCircuitOpen
+
+// Check that the Service is not switched off by the circuit breaker. Returns wrapped ErrServiceUnavailable if it is.
+func (s Service) Available() error {
+	return llm.Available(s.llmService())
+}
+
+// Connection details of the Service for the llm package.
+func (s Service) llmService() llm.Service {
+	return llm.Service{
+		Name:              s.Name,
+		APIStyle:          s.API_style.String,
+		Type:              s.Type,
+		URL:               s.URL.String,
+		Token:             s.Token,
+		RequestsPerMinute: s.RequestsPerMinute,
 	}
-	decision := decisionResp.Choices[0].Message.Content
-	log.Printf("AI sent decided: %s\n", reflection)
-	return decision, nil
 }
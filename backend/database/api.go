@@ -1,6 +1,7 @@
 package database
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -126,3 +127,91 @@ func ConflictingQuestionsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// Get how each Model answered each question - counts of YES, NO and unparseable answers.
+// Answers are counted for the model which actually answered, which is the fallback model when the game's one failed,
+// older rounds without answered_by fall back to the model of the answer or of the game.
+// Stats are split by witness mode of the game and by version of the reflection prompt,
+// answers older than prompts table have version 0.
+// Answers are parsed by ParseDecision(), so older free text answers are counted too.
+func AnswerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	query := `
+	SELECT
+		COALESCE(NULLIF(rounds.answered_by, ''), NULLIF(answers.Model, ''), games.model),
+		games.witness_mode,
+		questions.uuid,
+		questions.English,
+		COALESCE(prompts.Version, 0),
+		rounds.answer
+	FROM rounds
+	JOIN questions ON rounds.question_uuid = questions.uuid
+	JOIN investigations ON rounds.investigation_uuid = investigations.uuid
+	JOIN games ON investigations.game_uuid = games.uuid
+	LEFT JOIN answers ON rounds.answer_uuid = answers.UUID
+	LEFT JOIN prompts ON answers.ReflectionPromptUUID = prompts.UUID
+	WHERE rounds.answer IS NOT NULL AND rounds.answer != ''
+	`
+
+	rows, err := database.Query(query)
+	if err != nil {
+		msg := fmt.Sprintf("Error getting answer stats: %v", err)
+		fmt.Println(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type answerStats struct {
+		Model        string `json:"model"`
+		WitnessMode  string `json:"witness_mode"`
+		QuestionUUID string `json:"question_uuid"`
+		English      string `json:"english"`
+		Prompt       int    `json:"reflection_prompt_version"`
+		Yes          int    `json:"yes"`
+		No           int    `json:"no"`
+		Unparseable  int    `json:"unparseable"`
+	}
+	var results []*answerStats
+	type statsKey struct {
+		model, witnessMode, questionUUID string
+		prompt                           int
+	}
+	index := make(map[statsKey]*answerStats)
+	for rows.Next() {
+		var model sql.NullString
+		var witnessMode, questionUUID, english, answer string
+		var prompt int
+		if err := rows.Scan(&model, &witnessMode, &questionUUID, &english, &prompt, &answer); err != nil {
+			http.Error(w, "Error scanning data", http.StatusInternalServerError)
+			return
+		}
+		key := statsKey{model.String, witnessMode, questionUUID, prompt}
+		stats, ok := index[key]
+		if !ok {
+			stats = &answerStats{Model: model.String, WitnessMode: witnessMode, QuestionUUID: questionUUID, English: english, Prompt: prompt}
+			index[key] = stats
+			results = append(results, stats)
+		}
+		switch ParseDecision(answer) {
+		case DecisionYes:
+			stats.Yes++
+		case DecisionNo:
+			stats.No++
+		default:
+			stats.Unparseable++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		msg := fmt.Sprintf("Error iterating answer stats: %v", err)
+		fmt.Println(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,409 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/agajdosi/artificial_suspects/backend/llm"
+	"github.com/google/uuid"
+)
+
+// Pending describe jobs can be sent as one OpenAI batch instead of running them one by one, see llm/batch.go.
+// Each job is one line of the batch with the job UUID as custom_id. Jobs in the batch have JobBatched status
+// and BatchID set, results are imported by ImportDescribeBatch() or, offline, by ImportDescribeBatchResults().
+
+const JobBatched string = "batched"
+
+// Batch calls are billed at this share of the regular price.
+var BatchDiscount = 0.5
+
+// Batch of describe jobs, stored in describe_batches table.
+type DescribeBatch struct {
+	ID         string       `json:"ID"` // ID of the batch at the API, or "offline-<uuid>" for batch only written to the file
+	Service    string       `json:"Service"`
+	Model      string       `json:"Model"`
+	Prompt     string       `json:"Prompt"` // Prompt as it was sent, rendered from the template
+	PromptUUID string       `json:"PromptUUID"`
+	Status     string       `json:"Status"` // Last status reported by the API
+	Imported   bool         `json:"Imported"`
+	Created    string       `json:"Created"`
+	Timestamp  string       `json:"Timestamp"`
+	Sampling   llm.Sampling `json:"Sampling"` // Sampling parameters of the Model when the batch was made
+}
+
+func (b DescribeBatch) Offline() bool {
+	return len(b.ID) > 8 && b.ID[:8] == "offline-"
+}
+
+// Submit pending describe jobs of the Model as one batch. Returns the batch and number of jobs in it.
+func SubmitDescribeBatch(ctx context.Context, modelName string) (DescribeBatch, int, error) {
+	batch, requests, service, err := prepareDescribeBatch(modelName)
+	if err != nil || len(requests) == 0 {
+		return batch, 0, err
+	}
+	batch.ID, err = llm.SubmitBatch(ctx, service.llmService(), llm.BuildDescribeBatch(service.llmService(), requests))
+	if err != nil {
+		return batch, 0, err
+	}
+	batch.Status = "validating"
+	return batch, len(requests), saveDescribeBatch(batch, requests)
+}
+
+// Write pending describe jobs of the Model as batch input file, to be uploaded by hand.
+// Results downloaded later are imported by ImportDescribeBatchResults().
+func WriteDescribeBatchFile(modelName string, w io.Writer) (DescribeBatch, int, error) {
+	batch, requests, service, err := prepareDescribeBatch(modelName)
+	if err != nil || len(requests) == 0 {
+		return batch, 0, err
+	}
+	batch.ID = "offline-" + uuid.New().String()
+	batch.Status = "offline"
+	if _, err := w.Write(llm.BuildDescribeBatch(service.llmService(), requests)); err != nil {
+		return batch, 0, fmt.Errorf("could not write batch file: %w", err)
+	}
+	return batch, len(requests), saveDescribeBatch(batch, requests)
+}
+
+// Wait until the batch is finished at the API, checking its status every interval.
+// onStatus, if set, is called with each status.
+func PollDescribeBatch(ctx context.Context, batchID string, interval time.Duration, onStatus func(llm.BatchStatus)) (llm.BatchStatus, error) {
+	batch, err := GetDescribeBatch(batchID)
+	if err != nil {
+		return llm.BatchStatus{}, err
+	}
+	if batch.Offline() {
+		return llm.BatchStatus{}, fmt.Errorf("batch %s was written to the file, import its results file instead", batchID)
+	}
+	service, err := GetService(batch.Service)
+	if err != nil {
+		return llm.BatchStatus{}, err
+	}
+
+	for {
+		status, err := llm.GetBatch(ctx, service.llmService(), batchID)
+		if err != nil {
+			return status, err
+		}
+		if _, err := database.Exec("UPDATE describe_batches SET Status = $1, Timestamp = $2 WHERE ID = $3", status.Status, TimestampNow(), batchID); err != nil {
+			log.Printf("Could not save status of batch %s: %v\n", batchID, err)
+		}
+		if onStatus != nil {
+			onStatus(status)
+		}
+		if status.Finished() {
+			return status, nil
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return status, ctx.Err()
+		}
+	}
+}
+
+// Download results of the finished batch and import them, see ImportDescribeBatchResults().
+func ImportDescribeBatch(ctx context.Context, batchID string) (JobProgress, error) {
+	batch, err := GetDescribeBatch(batchID)
+	if err != nil {
+		return JobProgress{}, err
+	}
+	service, err := GetService(batch.Service)
+	if err != nil {
+		return JobProgress{}, err
+	}
+	status, err := llm.GetBatch(ctx, service.llmService(), batchID)
+	if err != nil {
+		return JobProgress{}, err
+	}
+	if !status.Finished() {
+		return JobProgress{}, fmt.Errorf("batch %s is not finished yet, status %s", batchID, status.Status)
+	}
+
+	progress := JobProgress{Errors: make(map[string]int)}
+	for _, fileID := range []string{status.OutputFileID, status.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		content, err := llm.DownloadBatchFile(ctx, service.llmService(), fileID)
+		if err != nil {
+			return progress, err
+		}
+		fileProgress, err := ImportDescribeBatchResults(content)
+		content.Close()
+		progress.add(fileProgress)
+		if err != nil {
+			return progress, err
+		}
+	}
+
+	// Requests missing in both files (expired or cancelled batch) go back to the queue.
+	_, err = database.Exec("UPDATE describe_jobs SET Status = $1, BatchID = '', Timestamp = $2 WHERE BatchID = $3 AND Status = $4",
+		JobPending, TimestampNow(), batchID, JobBatched)
+	if err != nil {
+		return progress, fmt.Errorf("could not return unfinished jobs of batch %s to the queue: %w", batchID, err)
+	}
+	return progress, markBatchesImported()
+}
+
+// Import results file of the batch: output or error file, as downloaded from the API.
+// Each line is paired with its describe job by custom_id, so the file can come from any batch made by this database.
+// Successful results are validated and saved as Descriptions, failed jobs are retried by the next run of the queue.
+func ImportDescribeBatchResults(r io.Reader) (JobProgress, error) {
+	progress := JobProgress{Errors: make(map[string]int)}
+	results, err := llm.ParseBatchResults(r)
+	if err != nil {
+		return progress, err
+	}
+	progress.Total = len(results)
+
+	for _, result := range results {
+		err := importBatchResult(result)
+		if errors.Is(err, errJobNotBatched) {
+			log.Printf("Skipping result %s: %v\n", result.CustomID, err)
+			progress.Total--
+			continue
+		}
+		if err != nil {
+			progress.Failed++
+			progress.Errors[err.Error()]++
+			continue
+		}
+		progress.Done++
+	}
+	return progress, markBatchesImported()
+}
+
+// Get the batch by its ID.
+func GetDescribeBatch(batchID string) (DescribeBatch, error) {
+	var b DescribeBatch
+	query := `SELECT ID, Service, Model, Prompt, PromptUUID, Status, Imported, Created, Timestamp,
+		Temperature, TopP, Seed, MaxTokens FROM describe_batches WHERE ID = $1`
+	err := database.QueryRow(query, batchID).Scan(&b.ID, &b.Service, &b.Model, &b.Prompt, &b.PromptUUID, &b.Status, &b.Imported, &b.Created, &b.Timestamp,
+		&b.Sampling.Temperature, &b.Sampling.TopP, &b.Sampling.Seed, &b.Sampling.MaxTokens)
+	if err != nil {
+		return b, fmt.Errorf("could not get batch %s: %w", batchID, err)
+	}
+	return b, nil
+}
+
+// Get batches of the Model submitted to the API whose results were not imported yet.
+func GetUnimportedDescribeBatches(modelName string) ([]DescribeBatch, error) {
+	var batches []DescribeBatch
+	query := `SELECT ID, Service, Model, Prompt, PromptUUID, Status, Imported, Created, Timestamp,
+		Temperature, TopP, Seed, MaxTokens FROM describe_batches WHERE Model = $1 AND Imported = 0 AND ID NOT LIKE 'offline-%' ORDER BY Created`
+	rows, err := database.Query(query, modelName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get batches: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b DescribeBatch
+		err := rows.Scan(&b.ID, &b.Service, &b.Model, &b.Prompt, &b.PromptUUID, &b.Status, &b.Imported, &b.Created, &b.Timestamp,
+			&b.Sampling.Temperature, &b.Sampling.TopP, &b.Sampling.Seed, &b.Sampling.MaxTokens)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan batch: %w", err)
+		}
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+// MARK: BATCH INTERNALS
+
+var errJobNotBatched = errors.New("no batched describe job with this UUID")
+
+// Build the requests for pending describe jobs of the Model. Returned batch has no ID yet.
+func prepareDescribeBatch(modelName string) (DescribeBatch, []llm.BatchDescribeRequest, Service, error) {
+	var batch DescribeBatch
+	model, err := GetModel(modelName)
+	if err != nil {
+		return batch, nil, Service{}, err
+	}
+	if !model.Visual {
+		return batch, nil, Service{}, fmt.Errorf("model %s is not visual, cannot describe images", modelName)
+	}
+	if err := CheckBudget(model); err != nil {
+		return batch, nil, Service{}, err
+	}
+	service, err := GetServiceForModel(modelName)
+	if err != nil {
+		return batch, nil, service, err
+	}
+
+	prompt, err := GetActivePrompt(PromptDescribe)
+	if err != nil {
+		return batch, nil, service, err
+	}
+	promptText, err := prompt.Render(nil)
+	if err != nil {
+		return batch, nil, service, err
+	}
+
+	if err := resetInterruptedJobs(modelName); err != nil {
+		return batch, nil, service, err
+	}
+	jobs, err := getDescribeJobs(modelName, JobPending)
+	if err != nil {
+		return batch, nil, service, err
+	}
+
+	var requests []llm.BatchDescribeRequest
+	for _, job := range jobs {
+		suspect, err := GetSuspect(job.SuspectUUID)
+		if err != nil {
+			return batch, nil, service, err
+		}
+		image, err := ImageToBase64(suspectImagePath(suspect))
+		if err != nil {
+			return batch, nil, service, fmt.Errorf("failed to convert image of suspect %s to base64: %w", suspect.UUID, err)
+		}
+		requests = append(requests, llm.BatchDescribeRequest{
+			CustomID:        job.UUID,
+			DescribeRequest: llm.DescribeRequest{Model: modelName, Prompt: promptText, ImageBase64: image, Sampling: model.Sampling},
+		})
+	}
+
+	now := TimestampNow()
+	batch = DescribeBatch{
+		Service:    service.Name,
+		Model:      modelName,
+		Prompt:     promptText,
+		PromptUUID: prompt.UUID,
+		Created:    now,
+		Timestamp:  now,
+		Sampling:   model.Sampling,
+	}
+	return batch, requests, service, nil
+}
+
+// Save the new batch and mark its jobs as batched.
+func saveDescribeBatch(batch DescribeBatch, requests []llm.BatchDescribeRequest) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO describe_batches (ID, Service, Model, Prompt, PromptUUID, Status, Imported, Created, Timestamp,
+		Temperature, TopP, Seed, MaxTokens) VALUES ($1, $2, $3, $4, $5, $6, 0, $7, $8, $9, $10, $11, $12)`,
+		batch.ID, batch.Service, batch.Model, batch.Prompt, batch.PromptUUID, batch.Status, batch.Created, batch.Timestamp,
+		batch.Sampling.Temperature, batch.Sampling.TopP, batch.Sampling.Seed, batch.Sampling.MaxTokens)
+	if err != nil {
+		return fmt.Errorf("could not save batch %s: %w", batch.ID, err)
+	}
+	for _, req := range requests {
+		_, err := tx.Exec("UPDATE describe_jobs SET Status = $1, BatchID = $2, Timestamp = $3 WHERE UUID = $4",
+			JobBatched, batch.ID, batch.Timestamp, req.CustomID)
+		if err != nil {
+			return fmt.Errorf("could not mark job %s as batched: %w", req.CustomID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Save the result as Description and finish its describe job.
+func importBatchResult(result llm.BatchResult) error {
+	var job DescribeJob
+	var batchID string
+	query := "SELECT UUID, SuspectUUID, Model, Status, Attempts, Error, Created, Timestamp, BatchID FROM describe_jobs WHERE UUID = $1 AND Status = $2"
+	err := database.QueryRow(query, result.CustomID, JobBatched).Scan(
+		&job.UUID, &job.SuspectUUID, &job.Model, &job.Status, &job.Attempts, &job.Error, &job.Created, &job.Timestamp, &batchID)
+	if err == sql.ErrNoRows {
+		return errJobNotBatched
+	}
+	if err != nil {
+		return fmt.Errorf("could not get describe job %s: %w", result.CustomID, err)
+	}
+
+	resultErr := saveBatchDescription(job, batchID, result)
+	job.Attempts++
+	job.Status = JobDone
+	job.Error = ""
+	if resultErr != nil {
+		job.Status = JobFailed
+		job.Error = resultErr.Error()
+	}
+	if err := saveDescribeJob(job); err != nil {
+		return err
+	}
+	return resultErr
+}
+
+func saveBatchDescription(job DescribeJob, batchID string, result llm.BatchResult) error {
+	if result.Err != "" {
+		return errors.New(result.Err)
+	}
+	batch, err := GetDescribeBatch(batchID)
+	if err != nil {
+		return err
+	}
+	model, err := GetModel(batch.Model)
+	if err != nil {
+		return err
+	}
+
+	description := Description{
+		UUID:          uuid.New().String(),
+		SuspectUUID:   job.SuspectUUID,
+		Service:       batch.Service,
+		Model:         batch.Model,
+		ReportedModel: result.Completion.Model,
+		Description:   result.Completion.Text,
+		Prompt:        batch.Prompt,
+		PromptUUID:    batch.PromptUUID,
+		Sampling:      batch.Sampling,
+		Timestamp:     TimestampNow(),
+		Usage:         newUsage(result.Completion.Usage, model),
+	}
+	description.Usage.Cost *= BatchDiscount
+	recordUsage(UsageDescribe, batch.Service, batch.Model, description.UUID, description.Usage)
+
+	validationErr := ValidateDescription(description.Description)
+	var rejection *RejectionError
+	if errors.As(validationErr, &rejection) {
+		description.Rejected = true
+		description.RejectionReason = rejection.Reason
+	}
+	if err := SaveDescription(description); err != nil {
+		return err
+	}
+	return validationErr
+}
+
+// Mark batches without any batched job left as imported.
+func markBatchesImported() error {
+	_, err := database.Exec(`UPDATE describe_batches SET Imported = 1, Timestamp = $1
+		WHERE Imported = 0 AND NOT EXISTS (SELECT 1 FROM describe_jobs WHERE BatchID = describe_batches.ID AND Status = $2)`,
+		TimestampNow(), JobBatched)
+	if err != nil {
+		return fmt.Errorf("could not mark batches as imported: %w", err)
+	}
+	return nil
+}
+
+// Sum of two progresses, for imports made of more files.
+func (p *JobProgress) add(other JobProgress) {
+	p.Total += other.Total
+	p.Done += other.Done
+	p.Failed += other.Failed
+	p.Paused += other.Paused
+	for message, count := range other.Errors {
+		p.Errors[message] += count
+	}
+}
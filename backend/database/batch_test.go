@@ -0,0 +1,362 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agajdosi/artificial_suspects/backend/llm"
+)
+
+const batchTestModel = "gpt-test"
+
+// Description long enough to pass ValidateDescription().
+var batchTestDescription = strings.Repeat("The person in the image has a calm face and looks at the camera with a gentle smile. ", 20)
+
+// Stand-in for the Batch API of OpenAI: files and batches endpoints. The batch is in progress
+// for the first poll and completed since the second. Output file is made by output from the uploaded input lines.
+type batchStandIn struct {
+	t      *testing.T
+	mu     sync.Mutex
+	input  []map[string]any // lines of the uploaded input file
+	polls  int
+	output func(input []map[string]any) string
+}
+
+func (s *batchStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/files":
+		if purpose := r.FormValue("purpose"); purpose != "batch" {
+			s.t.Errorf("uploaded file purpose = %q, want batch", purpose)
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			s.t.Errorf("no file uploaded: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var line map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				s.t.Errorf("uploaded line is not JSON: %v", err)
+			}
+			s.input = append(s.input, line)
+		}
+		fmt.Fprint(w, `{"id": "file-in", "object": "file", "purpose": "batch"}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/batches":
+		var request map[string]any
+		json.NewDecoder(r.Body).Decode(&request)
+		if request["input_file_id"] != "file-in" || request["endpoint"] != "/v1/chat/completions" {
+			s.t.Errorf("create batch request = %v, want the uploaded file for chat completions", request)
+		}
+		fmt.Fprint(w, `{"id": "batch-1", "object": "batch", "status": "validating"}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/batches/batch-1":
+		s.polls++
+		if s.polls == 1 {
+			fmt.Fprintf(w, `{"id": "batch-1", "status": "in_progress", "request_counts": {"total": %d}}`, len(s.input))
+			return
+		}
+		fmt.Fprintf(w, `{"id": "batch-1", "status": "completed", "output_file_id": "file-out",
+			"request_counts": {"total": %[1]d, "completed": %[1]d}}`, len(s.input))
+	case r.Method == http.MethodGet && r.URL.Path == "/files/file-out/content":
+		fmt.Fprint(w, s.output(s.input))
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Output line of the batch with successful completion.
+func batchOutputLine(customID, text string) string {
+	line, _ := json.Marshal(map[string]any{
+		"custom_id": customID,
+		"response": map[string]any{
+			"status_code": 200,
+			"body": map[string]any{
+				"model":   batchTestModel + "-2024",
+				"choices": []any{map[string]any{"message": map[string]any{"role": "assistant", "content": text}}},
+				"usage":   map[string]any{"prompt_tokens": 1000, "completion_tokens": 200},
+			},
+		},
+	})
+	return string(line) + "\n"
+}
+
+// Output line of the batch with failed request.
+func batchErrorLine(customID string) string {
+	line, _ := json.Marshal(map[string]any{
+		"custom_id": customID,
+		"error":     map[string]any{"code": "server_error", "message": "try again"},
+	})
+	return string(line) + "\n"
+}
+
+// Open new database with three suspects and their images, visual Model of the Service at serviceURL
+// and describe job queued for each suspect. Working directory is set so suspectImagePath() finds the images.
+func setupBatchTest(t *testing.T, serviceURL string) {
+	t.Helper()
+	openTestDB(t, "artsus.db")
+	if _, err := MigrateDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureDefaultPrompts(); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	images := filepath.Join(root, "front", "static", "suspects")
+	work := filepath.Join(root, "dev")
+	for _, dir := range []string{images, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(work)
+
+	queries := []string{
+		fmt.Sprintf("INSERT INTO services (Name, Type, API_style, URL, Token, Active) VALUES ('Stub', 'API', 'openai', '%s', 'sk-test', 1)", serviceURL),
+		fmt.Sprintf("INSERT INTO models (Name, Service, Visual, Allowed, InputPrice, OutputPrice) VALUES ('%s', 'Stub', 1, 1, 2, 10)", batchTestModel),
+	}
+	for i := 1; i <= 3; i++ {
+		suspect := fmt.Sprintf("suspect-%d", i)
+		if err := os.WriteFile(filepath.Join(images, suspect+".jpeg"), []byte("jpeg "+suspect), 0644); err != nil {
+			t.Fatal(err)
+		}
+		queries = append(queries, fmt.Sprintf("INSERT INTO suspects (uuid, image, timestamp) VALUES ('%[1]s', '%[1]s.jpeg', '')", suspect))
+	}
+	for _, query := range queries {
+		if _, err := database.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := EnqueueDescribeJobs(batchTestModel, 1); err != nil || n != 3 {
+		t.Fatalf("EnqueueDescribeJobs() = %d, %v, want 3 jobs", n, err)
+	}
+}
+
+// Status of the describe job of each suspect.
+func jobStatuses(t *testing.T) map[string]DescribeJob {
+	t.Helper()
+	jobs := make(map[string]DescribeJob)
+	for _, status := range []string{JobPending, JobBatched, JobDone, JobFailed} {
+		list, err := getDescribeJobs(batchTestModel, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, job := range list {
+			jobs[job.SuspectUUID] = job
+		}
+	}
+	return jobs
+}
+
+func TestDescribeBatch(t *testing.T) {
+	// First job is described, second refused and third failed at the API.
+	var customIDs []string
+	standIn := &batchStandIn{t: t, output: func(input []map[string]any) string {
+		var out strings.Builder
+		for i, line := range input {
+			id := line["custom_id"].(string)
+			customIDs = append(customIDs, id)
+			switch i {
+			case 0:
+				out.WriteString(batchOutputLine(id, batchTestDescription))
+			case 1:
+				out.WriteString(batchOutputLine(id, "I'm sorry, I can't help with identifying people."))
+			default:
+				out.WriteString(batchErrorLine(id))
+			}
+		}
+		return out.String()
+	}}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	setupBatchTest(t, server.URL)
+	ctx := context.Background()
+
+	batch, n, err := SubmitDescribeBatch(ctx, batchTestModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.ID != "batch-1" || n != 3 {
+		t.Fatalf("SubmitDescribeBatch() = %s with %d jobs, want batch-1 with 3", batch.ID, n)
+	}
+	if len(standIn.input) != 3 {
+		t.Fatalf("uploaded %d lines, want 3", len(standIn.input))
+	}
+	for _, line := range standIn.input {
+		body, _ := json.Marshal(line["body"])
+		if line["url"] != "/v1/chat/completions" || !strings.Contains(string(body), `"model":"`+batchTestModel+`"`) ||
+			!strings.Contains(string(body), "data:image/jpeg;base64,") {
+			t.Errorf("uploaded line = %v, want chat completion of the model with the image", line)
+		}
+	}
+	for suspect, job := range jobStatuses(t) {
+		if job.Status != JobBatched {
+			t.Errorf("job of %s is %s after submit, want %s", suspect, job.Status, JobBatched)
+		}
+	}
+
+	var statuses []string
+	status, err := PollDescribeBatch(ctx, batch.ID, time.Millisecond, func(s llm.BatchStatus) { statuses = append(statuses, s.Status) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(statuses, ",") != "in_progress,completed" || status.OutputFileID != "file-out" {
+		t.Errorf("polled statuses %v, last %+v, want in_progress then completed with output file", statuses, status)
+	}
+
+	progress, err := ImportDescribeBatch(ctx, batch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Total != 3 || progress.Done != 1 || progress.Failed != 2 || len(progress.Errors) != 2 {
+		t.Errorf("ImportDescribeBatch() = %+v, want 1 done and 2 failed by different errors", progress)
+	}
+
+	jobs := make(map[string]DescribeJob)
+	for _, job := range jobStatuses(t) {
+		jobs[job.UUID] = job
+	}
+	described, refused, failed := jobs[customIDs[0]], jobs[customIDs[1]], jobs[customIDs[2]]
+	if described.Status != JobDone || refused.Status != JobFailed || failed.Status != JobFailed {
+		t.Errorf("job statuses %s, %s, %s, want done, failed, failed", described.Status, refused.Status, failed.Status)
+	}
+	if !strings.Contains(failed.Error, "server_error") || failed.Attempts != 1 {
+		t.Errorf("failed job = %+v, want the error of its line and one attempt", failed)
+	}
+
+	descriptions, err := GetDescriptionsForSuspect(described.SuspectUUID, batchTestModel, true)
+	if err != nil || len(descriptions) != 1 {
+		t.Fatalf("descriptions of described suspect: %d, %v, want 1", len(descriptions), err)
+	}
+	d := descriptions[0]
+	if d.Description != batchTestDescription || d.ReportedModel != batchTestModel+"-2024" || d.Prompt != batch.Prompt || d.Rejected {
+		t.Errorf("description = %+v, want the batch output", d)
+	}
+	var cost float64
+	if err := database.QueryRow("SELECT Cost FROM token_usage WHERE ReferenceUUID = $1", d.UUID).Scan(&cost); err != nil {
+		t.Fatal(err)
+	}
+	if want := (1000*2 + 200*10) / 1e6 * BatchDiscount; cost != want {
+		t.Errorf("cost = %v, want %v with the batch discount", cost, want)
+	}
+
+	var rejected bool
+	var reason string
+	err = database.QueryRow("SELECT Rejected, RejectionReason FROM descriptions WHERE SuspectUUID = $1", refused.SuspectUUID).Scan(&rejected, &reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rejected || reason == "" {
+		t.Errorf("refused description rejected %v with reason %q, want rejected", rejected, reason)
+	}
+	var count int
+	if err := database.QueryRow("SELECT COUNT(*) FROM descriptions WHERE SuspectUUID = $1", failed.SuspectUUID).Scan(&count); err != nil || count != 0 {
+		t.Errorf("failed request saved %d descriptions, %v, want none", count, err)
+	}
+
+	stored, err := GetDescribeBatch(batch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Imported || stored.Status != "completed" {
+		t.Errorf("stored batch = %+v, want completed and imported", stored)
+	}
+}
+
+func TestImportDescribeBatchResults(t *testing.T) {
+	setupBatchTest(t, "http://127.0.0.1:1") // offline batch never calls the API
+
+	var file bytes.Buffer
+	batch, n, err := WriteDescribeBatchFile(batchTestModel, &file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !batch.Offline() || n != 3 {
+		t.Fatalf("WriteDescribeBatchFile() = %s with %d jobs, want offline batch with 3", batch.ID, n)
+	}
+	if _, err := PollDescribeBatch(context.Background(), batch.ID, time.Millisecond, nil); err == nil {
+		t.Error("PollDescribeBatch() of offline batch succeeded, want error")
+	}
+
+	// Results come in two files, the second one also with a line unknown to this database.
+	var lines []string
+	scanner := bufio.NewScanner(&file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var line struct {
+			CustomID string `json:"custom_id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line.CustomID)
+	}
+	first := batchOutputLine(lines[0], batchTestDescription) + batchErrorLine(lines[1])
+	second := "\n" + batchOutputLine(lines[2], batchTestDescription) + batchOutputLine("unknown-job", batchTestDescription)
+
+	progress, err := ImportDescribeBatchResults(strings.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Total != 2 || progress.Done != 1 || progress.Failed != 1 {
+		t.Errorf("import of the first file = %+v, want 1 done and 1 failed", progress)
+	}
+	if stored, _ := GetDescribeBatch(batch.ID); stored.Imported {
+		t.Error("batch is imported while one of its jobs is still batched")
+	}
+
+	progress, err = ImportDescribeBatchResults(strings.NewReader(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Total != 1 || progress.Done != 1 {
+		t.Errorf("import of the second file = %+v, want 1 done and the unknown line skipped", progress)
+	}
+	if stored, _ := GetDescribeBatch(batch.ID); !stored.Imported {
+		t.Error("batch is not imported after all its jobs")
+	}
+
+	// Imported again, the results belong to no batched job.
+	progress, err = ImportDescribeBatchResults(strings.NewReader(first))
+	if err != nil || progress.Total != 0 {
+		t.Errorf("repeated import = %+v, %v, want all lines skipped", progress, err)
+	}
+	counts, err := GetDescribeJobCounts(batchTestModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[JobDone] != 2 || counts[JobFailed] != 1 || counts[JobBatched] != 0 {
+		t.Errorf("job counts = %v, want 2 done and 1 failed", counts)
+	}
+
+	if _, err := ImportDescribeBatchResults(strings.NewReader("not json\n")); err == nil {
+		t.Error("import of malformed file succeeded, want error")
+	}
+}
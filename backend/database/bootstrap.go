@@ -0,0 +1,218 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Bootstrap builds new database from the repository alone - the migrations, the default questions,
+// services and models of data.go and the suspects and descriptions of seed/ - instead of copying the binary default.db.
+// OpenDB() bootstraps every new database this way. The shipped default.db is bootstrapped the same way,
+// after changing the seed rebuild it by: dev db bootstrap --output ../backend/database/default.db
+
+// Suspects and their descriptions, diffable JSON exported from the original default.db.
+//
+//go:embed seed/*.json
+var seedFiles embed.FS
+
+// Suspect as stored in seed/suspects.json.
+type seedSuspect struct {
+	UUID      string
+	Image     string
+	Timestamp string
+}
+
+// Description as stored in seed/descriptions.json.
+type seedDescription struct {
+	UUID        string
+	SuspectUUID string
+	Service     string
+	Model       string
+	Prompt      string
+	Description string
+	Timestamp   string
+}
+
+// Counts of the rows in the database made by BootstrapDB().
+type BootstrapResult struct {
+	Questions    int
+	Services     int
+	Models       int
+	Suspects     int
+	Descriptions int
+}
+
+// Create new database at gameDBPath by EnsureDBAvailable() and register the suspects from the images in imagesDir
+// which are not seeded yet, empty imagesDir registers none. The database stays opened.
+// Seeded services have no tokens, set them by dev set-token command before playing.
+func BootstrapDB(gameDBPath, imagesDir string) (BootstrapResult, error) {
+	var result BootstrapResult
+	if _, err := os.Stat(gameDBPath); err == nil {
+		return result, fmt.Errorf("database %s already exists", gameDBPath)
+	}
+	if imagesDir != "" {
+		if _, err := os.Stat(imagesDir); err != nil {
+			return result, fmt.Errorf("could not read images of suspects: %w", err)
+		}
+	}
+
+	if err := EnsureDBAvailable(gameDBPath); err != nil {
+		return result, err
+	}
+	if imagesDir != "" {
+		if _, err := RegisterSuspects(imagesDir); err != nil {
+			return result, err
+		}
+	}
+
+	counts := map[string]*int{
+		"questions":    &result.Questions,
+		"services":     &result.Services,
+		"models":       &result.Models,
+		"suspects":     &result.Suspects,
+		"descriptions": &result.Descriptions,
+	}
+	for table, count := range counts {
+		n, err := countRows(table)
+		if err != nil {
+			return result, err
+		}
+		*count = n
+	}
+	return result, nil
+}
+
+// Fill the content of new database: the questions, services and models of data.go
+// and the suspects and descriptions of seed/. Rows which are already in the database are kept.
+func SeedContent() error {
+	if _, err := SeedQuestions(); err != nil {
+		return err
+	}
+	for _, s := range defaultServices {
+		_, err := database.Exec("INSERT OR IGNORE INTO services (Name, Type, API_style, URL, Token, Active) VALUES ($1, $2, $3, $4, '', $5)",
+			s.Name, s.Type, s.API_style, s.URL, s.Active)
+		if err != nil {
+			return fmt.Errorf("could not seed service %s: %w", s.Name, err)
+		}
+	}
+	for _, m := range defaultModels {
+		_, err := database.Exec("INSERT OR IGNORE INTO models (Name, Service, Visual, Allowed, Historical) VALUES ($1, $2, $3, $4, $5)",
+			m.Name, m.Service, m.Visual, m.Allowed, m.Historical)
+		if err != nil {
+			return fmt.Errorf("could not seed model %s: %w", m.Name, err)
+		}
+	}
+
+	var suspects []seedSuspect
+	if err := readSeed("seed/suspects.json", &suspects); err != nil {
+		return err
+	}
+	for _, s := range suspects {
+		_, err := database.Exec("INSERT OR IGNORE INTO suspects (uuid, image, timestamp) VALUES ($1, $2, $3)", s.UUID, s.Image, s.Timestamp)
+		if err != nil {
+			return fmt.Errorf("could not seed suspect %s: %w", s.UUID, err)
+		}
+	}
+
+	// Seeded descriptions are validated like the generated ones, those which fail are stored rejected.
+	var descriptions []seedDescription
+	if err := readSeed("seed/descriptions.json", &descriptions); err != nil {
+		return err
+	}
+	for _, d := range descriptions {
+		var rejection *RejectionError
+		errors.As(ValidateDescription(d.Description), &rejection)
+		rejected, reason := rejection != nil, ""
+		if rejected {
+			reason = rejection.Reason
+		}
+		_, err := database.Exec(`INSERT OR IGNORE INTO descriptions (UUID, SuspectUUID, Service, Model, Description, Prompt, Timestamp,
+			Rejected, RejectionReason) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			d.UUID, d.SuspectUUID, d.Service, d.Model, d.Description, d.Prompt, d.Timestamp, rejected, reason)
+		if err != nil {
+			return fmt.Errorf("could not seed description %s: %w", d.UUID, err)
+		}
+	}
+	log.Printf("%s Seeded %d questions, %d services, %d models, %d suspects and %d descriptions", emoDB,
+		len(defaultQuestions), len(defaultServices), len(defaultModels), len(suspects), len(descriptions))
+	return nil
+}
+
+func readSeed(file string, v any) error {
+	content, err := seedFiles.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("could not parse %s: %w", file, err)
+	}
+	return nil
+}
+
+// Save the questions from defaultQuestions which are not in the database yet. Returns number of saved questions.
+func SeedQuestions() (int, error) {
+	before, err := countRows("questions")
+	if err != nil {
+		return 0, err
+	}
+	for _, q := range defaultQuestions {
+		if err := SaveQuestion(q); err != nil {
+			return 0, fmt.Errorf("could not seed question %q: %w", q.English, err)
+		}
+	}
+	after, err := countRows("questions")
+	return after - before, err
+}
+
+// Save Suspect for each image in the directory which is not in the database yet. Images are named
+// by SHA-256 of their content (see dev import command), which is also used as UUID of the Suspect.
+// Returns number of saved suspects.
+func RegisterSuspects(imagesDir string) (int, error) {
+	files, err := os.ReadDir(imagesDir)
+	if err != nil {
+		return 0, fmt.Errorf("could not read images of suspects: %w", err)
+	}
+	before, err := countRows("suspects")
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !IsImage(file.Name()) {
+			continue
+		}
+		suspect := Suspect{
+			UUID:  strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())),
+			Image: file.Name(),
+		}
+		if err := SaveSuspect(suspect); err != nil {
+			return 0, err
+		}
+	}
+	after, err := countRows("suspects")
+	return after - before, err
+}
+
+func countRows(table string) (int, error) {
+	var count int
+	if err := database.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not count %s: %w", table, err)
+	}
+	return count, nil
+}
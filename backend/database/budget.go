@@ -0,0 +1,184 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Returned when the Model or its Service spent its daily or monthly budget.
+// Model is then not Allowed until the window resets - next day or next month.
+var ErrBudgetExceeded = errors.New("spending cap reached")
+
+// Spending of the Model or Service against its cap in one window.
+type BudgetStatus struct {
+	Scope  string  `json:"Scope"`  // model or service
+	Name   string  `json:"Name"`   // name of the Model or Service
+	Window string  `json:"Window"` // day or month
+	Period string  `json:"Period"` // 2006-01-02 for day, 2006-01 for month
+	Limit  float64 `json:"Limit"`  // USD, 0 means no cap
+	Spent  float64 `json:"Spent"`  // USD
+}
+
+func (b BudgetStatus) Exceeded() bool {
+	return b.Limit > 0 && b.Spent >= b.Limit
+}
+
+// Check that neither the Model nor its Service spent their daily or monthly budget.
+// Returns wrapped ErrBudgetExceeded if they did. The first time the cap is reached
+// in the window, the event is logged and saved into budget_events table.
+func CheckBudget(model Model) error {
+	service, err := GetService(model.Service)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := budgetStatuses(model, service)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Exceeded() {
+			recordBudgetEvent(status)
+			return fmt.Errorf("%s %s spent %.4f of %.4f USD %s budget: %w",
+				status.Scope, status.Name, status.Spent, status.Limit, status.Window, ErrBudgetExceeded)
+		}
+	}
+	return nil
+}
+
+// Get daily and monthly spending of all Models and Services, including those without caps.
+func GetBudgetStatuses() ([]BudgetStatus, error) {
+	var statuses []BudgetStatus
+	services, err := GetServices()
+	if err != nil {
+		return statuses, err
+	}
+	for _, service := range services {
+		for _, window := range []string{"day", "month"} {
+			status, err := spending("service", service.Name, window, service.DailyBudget, service.MonthlyBudget)
+			if err != nil {
+				return statuses, err
+			}
+			statuses = append(statuses, status)
+		}
+	}
+
+	models, err := GetModels(false, "")
+	if err != nil {
+		return statuses, err
+	}
+	for _, model := range models {
+		for _, window := range []string{"day", "month"} {
+			status, err := spending("model", model.Name, window, model.DailyBudget, model.MonthlyBudget)
+			if err != nil {
+				return statuses, err
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// Set the daily and monthly caps in USD of the Model (scope "model") or Service (scope "service"), 0 means no cap.
+func SetBudget(scope, name string, daily, monthly float64) error {
+	table := "models"
+	if scope == "service" {
+		table = "services"
+	} else if scope != "model" {
+		return fmt.Errorf("unknown budget scope %q, use model or service", scope)
+	}
+	if daily < 0 || monthly < 0 {
+		return fmt.Errorf("budget of %s %s cannot be negative", scope, name)
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET DailyBudget = $1, MonthlyBudget = $2 WHERE Name = $3", table)
+	result, err := database.Exec(query, daily, monthly, name)
+	if err != nil {
+		return fmt.Errorf("could not set budget of %s %s: %w", scope, name, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s %s not found", scope, name)
+	}
+	return nil
+}
+
+// Statuses of the caps which are set for the Model and its Service.
+func budgetStatuses(model Model, service Service) ([]BudgetStatus, error) {
+	var statuses []BudgetStatus
+	caps := []struct {
+		scope, name    string
+		daily, monthly float64
+	}{
+		{"model", model.Name, model.DailyBudget, model.MonthlyBudget},
+		{"service", service.Name, service.DailyBudget, service.MonthlyBudget},
+	}
+	for _, c := range caps {
+		for _, window := range []string{"day", "month"} {
+			if (window == "day" && c.daily <= 0) || (window == "month" && c.monthly <= 0) {
+				continue
+			}
+			status, err := spending(c.scope, c.name, window, c.daily, c.monthly)
+			if err != nil {
+				return statuses, err
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// Sum the cost in token_usage for the Model or Service in the current day or month.
+func spending(scope, name, window string, daily, monthly float64) (BudgetStatus, error) {
+	status := BudgetStatus{Scope: scope, Name: name, Window: window}
+	now := time.Now()
+	column := "Model"
+	if scope == "service" {
+		column = "Service"
+	}
+
+	var query string
+	switch window {
+	case "day":
+		status.Period = now.Format(time.DateOnly)
+		status.Limit = daily
+		query = fmt.Sprintf("SELECT COALESCE(SUM(Cost), 0) FROM token_usage WHERE %s = $1 AND Day = $2", column)
+	case "month":
+		status.Period = now.Format("2006-01")
+		status.Limit = monthly
+		query = fmt.Sprintf("SELECT COALESCE(SUM(Cost), 0) FROM token_usage WHERE %s = $1 AND substr(Day, 1, 7) = $2", column)
+	}
+
+	err := database.QueryRow(query, name, status.Period).Scan(&status.Spent)
+	if err != nil {
+		return status, fmt.Errorf("could not get spending of %s %s: %w", scope, name, err)
+	}
+	return status, nil
+}
+
+// Save the event of reaching the cap, only once per window.
+func recordBudgetEvent(status BudgetStatus) {
+	query := `INSERT OR IGNORE INTO budget_events (Scope, Name, Window, Period, BudgetLimit, Spent, Timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := database.Exec(query, status.Scope, status.Name, status.Window, status.Period, status.Limit, status.Spent, TimestampNow())
+	if err != nil {
+		log.Printf("Could not save budget event for %s %s: %v\n", status.Scope, status.Name, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("💸 %s %s reached its %s budget: spent %.4f of %.4f USD in %s, disabled until the %s ends\n",
+			status.Scope, status.Name, status.Window, status.Spent, status.Limit, status.Period, status.Window)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+)
+
+// Policy of reusing already generated Answers for the same question, description (or portrait in vision mode), model and prompts.
+// Reuse saves money and makes the witness consistent across players, fresh answers show its instability.
+type CachePolicy struct {
+	Probability float64 // Chance to reuse the cached Answer: 1 always, 0 never
+}
+
+var (
+	CacheAlways = CachePolicy{Probability: 1}
+	CacheNever  = CachePolicy{Probability: 0}
+)
+
+var (
+	answerCacheMu     sync.RWMutex
+	answerCachePolicy = CacheNever
+)
+
+// Parse the policy from "always", "never" or probability between 0 and 1.
+func ParseCachePolicy(text string) (CachePolicy, error) {
+	switch text {
+	case "always":
+		return CacheAlways, nil
+	case "never", "":
+		return CacheNever, nil
+	}
+	p, err := strconv.ParseFloat(text, 64)
+	if err != nil || p < 0 || p > 1 {
+		return CacheNever, fmt.Errorf("answer cache policy must be always, never or probability between 0 and 1, got %q", text)
+	}
+	return CachePolicy{Probability: p}, nil
+}
+
+func (p CachePolicy) String() string {
+	switch p.Probability {
+	case 1:
+		return "always"
+	case 0:
+		return "never"
+	}
+	return strconv.FormatFloat(p.Probability, 'f', -1, 64)
+}
+
+// Set the policy used by GenerateAnswer(). Default is CacheNever.
+func SetAnswerCachePolicy(policy CachePolicy) {
+	answerCacheMu.Lock()
+	defer answerCacheMu.Unlock()
+	answerCachePolicy = policy
+}
+
+func (p CachePolicy) reuse() bool {
+	return p.Probability >= 1 || (p.Probability > 0 && rand.Float64() < p.Probability)
+}
+
+// Get a copy of the cached Answer with the same key (and number of samples and sampling parameters) as the answer, if the policy decides to reuse it.
+// Only Answers with valid Decision are reused. Returned Answer has new UUID and CachedFromUUID
+// pointing to the original one, so the stats can tell reused Answers apart.
+func cachedAnswer(answer Answer) (Answer, bool) {
+	answerCacheMu.RLock()
+	policy := answerCachePolicy
+	answerCacheMu.RUnlock()
+	if !policy.reuse() {
+		return answer, false
+	}
+
+	var cached Answer
+	var decision string
+	query := `SELECT UUID, Reflection, RawDecision, Decision, YesVotes, NoVotes, UnparseableVotes FROM answers
+		WHERE QuestionUUID = $1 AND DescriptionUUID = $2 AND Model = $3
+		AND ReflectionPromptUUID = $4 AND DecisionPromptUUID = $5 AND Samples = $6
+		AND Temperature IS $7 AND TopP IS $8 AND Seed IS $9 AND MaxTokens IS $10
+		AND Decision IN ($11, $12) AND CachedFromUUID = ''
+		AND WitnessMode = $13 AND SuspectUUID = $14
+		ORDER BY RANDOM() LIMIT 1`
+	err := database.QueryRow(query, answer.QuestionUUID, answer.DescriptionUUID, answer.Model,
+		answer.ReflectionPromptUUID, answer.DecisionPromptUUID, answer.Samples,
+		answer.Sampling.Temperature, answer.Sampling.TopP, answer.Sampling.Seed, answer.Sampling.MaxTokens,
+		string(DecisionYes), string(DecisionNo), answer.WitnessMode, answer.SuspectUUID,
+	).Scan(&cached.UUID, &cached.Reflection, &cached.RawDecision, &decision,
+		&cached.Votes.Yes, &cached.Votes.No, &cached.Votes.Unparseable)
+	if err == sql.ErrNoRows {
+		return answer, false
+	}
+	if err != nil {
+		log.Printf("Could not look up cached answer: %v\n", err)
+		return answer, false
+	}
+
+	answer.CachedFromUUID = cached.UUID
+	answer.Reflection = cached.Reflection
+	answer.RawDecision = cached.RawDecision
+	answer.Votes = cached.Votes
+	answer.Decision = Decision(decision)
+	answer.Text = decision
+	answer.Timestamp = TimestampNow()
+	log.Printf("Reusing cached answer %s of model %s\n", cached.UUID, answer.Model)
+	return answer, true
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/agajdosi/artificial_suspects/backend/llm"
+)
+
+// Token and URL of the Service set outside of the database. Empty values do not override.
+type ServiceOverride struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// Config file with the overrides, e.g.:
+//
+//	{"services": {"OpenAI": {"token": "sk-..."}, "Ollama": {"url": "http://ollama:11434"}}}
+type ServiceConfig struct {
+	Services map[string]ServiceOverride `json:"services"`
+}
+
+var (
+	serviceConfigMu sync.RWMutex
+	serviceConfig   ServiceConfig
+)
+
+// Load the config file with the overrides of the Services, see ServiceConfig.
+func LoadServiceConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	var config ServiceConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	serviceConfigMu.Lock()
+	defer serviceConfigMu.Unlock()
+	serviceConfig = config
+	log.Printf("Loaded overrides of %d services from %s", len(config.Services), path)
+	return nil
+}
+
+// Names of the environment variables overriding token and URL of the Service,
+// e.g. ARTSUS_OPENAI_TOKEN and ARTSUS_OPENAI_URL for Service "OpenAI".
+func ServiceEnvNames(serviceName string) (string, string) {
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, serviceName)
+	return "ARTSUS_" + name + "_TOKEN", "ARTSUS_" + name + "_URL"
+}
+
+// Replace token and URL of the Service stored in the database by the overrides.
+// Environment variables take precedence over the config file.
+func applyServiceOverrides(service *Service) {
+	serviceConfigMu.RLock()
+	override := serviceConfig.Services[service.Name]
+	serviceConfigMu.RUnlock()
+
+	tokenEnv, urlEnv := ServiceEnvNames(service.Name)
+	if token := os.Getenv(tokenEnv); token != "" {
+		override.Token = token
+	}
+	if url := os.Getenv(urlEnv); url != "" {
+		override.URL = url
+	}
+
+	if override.Token != "" {
+		service.Token = llm.Secret(override.Token)
+	}
+	if override.URL != "" {
+		service.URL = sql.NullString{String: override.URL, Valid: true}
+	}
+}
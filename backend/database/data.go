@@ -11,358 +11,449 @@
 
 package database
 
+import "database/sql"
+
 // Prepacked default questions for the game. This gets filled into
-// the questions database.
+// the questions database by SeedQuestions(). UUIDs are the ones of the original default.db,
+// so the answers and statistics of a question match across the databases.
 var defaultQuestions = []Question{
 	{
+		UUID:    "c7e33c8d-5cbb-4165-ad05-b6afd6718419",
 		English: "Does the suspect like pizza?",
 		Czech:   "Má podezřelý rád pizzu?",
 		Polish:  "Czy podejrzany lubi pizzę?",
 		Topic:   "basic", Level: 1,
 	},
 	{
+		UUID:    "d37f0d62-f0c8-4ba8-951d-22e216a4035b",
 		English: "Is the suspect leftist?",
 		Czech:   "Je podezřelý levičák?",
 		Polish:  "Czy podejrzany jest lewicowy?",
 		Topic:   "political", Level: 1,
 	},
 	{
+		UUID:    "92231acd-a6e1-431b-a260-e23414fc9b6b",
 		English: "Does the suspect have depressions?",
 		Czech:   "Má podezřelý deprese?",
 		Polish:  "Czy podejrzany ma depresje?",
 		Topic:   "psychological", Level: 1,
 	},
 	{
+		UUID:    "f7768180-d6c0-476d-bb6b-c31b33d11eeb",
 		English: "Is the suspect a fan of social media?",
 		Czech:   "Je podezřelý fanouškem sociálních sítí?",
 		Polish:  "Czy podejrzany jest fanem mediów społecznościowych?",
 		Topic:   "sociological", Level: 1,
 	},
 	{
+		UUID:    "d03b514f-65d9-4b60-a988-71d0eb58b703",
 		English: "Does the suspect enjoy traveling?",
 		Czech:   "Má podezřelý rád cestování?",
 		Polish:  "Czy podejrzany lubi podróże?",
 		Topic:   "basic", Level: 1,
 	},
 	{
+		UUID:    "5c57ab80-e716-42cd-bd8b-e246ee3edb1d",
 		English: "Is the suspect environmentally conscious?",
 		Czech:   "Je podezřelý ohleduplný k životnímu prostředí?",
 		Polish:  "Czy podejrzany ma świadomość ekologiczną?",
 		Topic:   "political", Level: 1,
 	},
 	{
+		UUID:    "e38dd0e9-5e0e-4ba0-93a2-91768a5fe2f3",
 		English: "Does the suspect attend therapy?",
 		Czech:   "Navštěvuje podezřelý terapii?",
 		Polish:  "Czy podejrzany chodzi na terapię?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "b625e78e-644b-4299-89ad-203a49c9017a",
 		English: "Does the suspect believe in traditional family?",
 		Czech:   "Vyznává podezřelý tradiční rodinu?",
 		Polish:  "Czy podejrzany wierzy w tradycyjną rodzinę?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "0d5beefc-4da7-4eac-a60a-52a9b96e16c4",
 		English: "Is the suspect vegetarian?",
 		Czech:   "Je podezřelý vegetarián?",
 		Polish:  "Czy podejrzany jest wegetarianinem?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "f88f3174-ee1b-40d9-9c23-9e720c633d9d",
 		English: "Is the suspect vegan?",
 		Czech:   "Je podezřelý vegan?",
 		Polish:  "Czy podejrzany jest weganinem?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "776d7a2b-87e2-40ee-8e2f-bb04aa3edb4d",
 		English: "Does the suspect vote regularly?",
 		Czech:   "Chodí podezřelý pravidelně k volbám?",
 		Polish:  "Czy podejrzany regularnie głosuje?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "0f76906f-7aad-42ba-8f3f-b0ecb90cf883",
 		English: "Does the suspect struggle with anxiety?",
 		Czech:   "Má podezřelý problémy s úzkostmi?",
 		Polish:  "Czy podejrzany zmaga się z lękiem?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "292ace32-d66b-463c-89f4-2b86f50f0618",
 		English: "Is the suspect an extrovert?",
 		Czech:   "Je podezřelý extrovert?",
 		Polish:  "Czy podejrzany jest ekstrawertykiem?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "b99ed639-a066-42fb-91e2-cfc9340440c8",
 		English: "Does the suspect sport regularly?",
 		Czech:   "Sportuje podezřelý pravidelně?",
 		Polish:  "Czy podejrzany regularnie uprawia sport?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "4d009a7c-0da5-45c6-b37b-ebad016b9ed5",
 		English: "Does the suspect have strong political opinions?",
 		Czech:   "Má podezřelý vyhraněné politické názory?",
 		Polish:  "Czy podejrzany ma silne poglądy polityczne?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "1734c6ae-d5ba-4a61-83d6-bb1c480d4eae",
 		English: "Does the suspect meditate?",
 		Czech:   "Medituje podezřelý?",
 		Polish:  "Czy podejrzany medytuje?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "4447871c-ff55-43b3-a268-b721f9944a62",
 		English: "Is the suspect part of a secret community?",
 		Czech:   "Je podezřelý členem tajné komunity?",
 		Polish:  "Czy podejrzany jest częścią tajnej społeczności?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "3cd77f9d-e888-4814-bc89-3f7057711ce4",
 		English: "Does the suspect enjoy cooking?",
 		Czech:   "Je podezřelý členem uzavřené komunity?",
 		Polish:  "Czy podejrzany lubi gotować?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "76a392e8-9a37-410c-94b2-2190c6eb8b5f",
 		English: "Is the suspect involved in activism?",
 		Czech:   "Je podezřelý zapojen do aktivismu?",
 		Polish:  "Czy podejrzany jest zaangażowany w aktywizm?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "69bf41f1-a59d-4446-9162-5d0707be7075",
 		English: "Does the suspect have mood swings?",
 		Czech:   "Má podezřelý výkyvy nálad?",
 		Polish:  "Czy podejrzany miewa wahania nastroju?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "32afa34e-b3ca-4ca7-8c2c-52c5a64db156",
 		English: "Does the suspect follow trends?",
 		Czech:   "Řídí se podezřelý trendy?",
 		Polish:  "Czy podejrzany podąża za trendami?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "63e864f6-55ac-4b0f-b56a-1f8114f478dc",
 		English: "Is the suspect a fan of sci-fi movies?",
 		Czech:   "Je podezřelý fanouškem sci-fi filmů?",
 		Polish:  "Czy podejrzany jest fanem filmów science-fiction?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "995be114-a59b-4a5d-9a54-99a7fb7dcd86",
 		English: "Does the suspect lean towards conservatism?",
 		Czech:   "Přiklání se podezřelý ke konzervatismu?",
 		Polish:  "Czy podejrzany skłania się ku konserwatyzmowi?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "82f29cac-f3bd-463b-ab23-2fbc84be84fb",
 		English: "Does the suspect enjoy large social gatherings?",
 		Czech:   "Má podezřelý rád velká společenská setkání?",
 		Polish:  "Czy podejrzany lubi duże spotkania towarzyskie?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "07c5201b-1f7c-4ccf-97c3-9549a90d66b9",
 		English: "Does the suspect enjoy hiking?",
 		Czech:   "Má podezřelý rád pěší turistiku?",
 		Polish:  "Czy podejrzany lubi piesze wędrówki?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "6b32a23e-3d63-4ecd-a1fa-3dde8530b434",
 		English: "Does the suspect have progressive views?",
 		Czech:   "Má podezřelý pokrokové názory?",
 		Polish:  "Czy podejrzany ma postępowe poglądy?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "d8e92f25-8028-4954-8fe3-0768579ae439",
 		English: "Does the suspect think they are member of a minority?",
 		Czech:   "Myslí si podezřelý, že je menšinou?",
 		Polish:  "Czy podejrzany uważa się za członka mniejszości?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "fd218bb6-de46-41da-a81e-4205e961c6d5",
 		English: "Does the suspect enjoy reading books?",
 		Czech:   "Čte podezřelý rád knihy?",
 		Polish:  "Czy podejrzany lubi czytać książki?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "09b09711-b100-43ca-81e4-46ec5108374b",
 		English: "Is the suspect politically active online?",
 		Czech:   "Je podezřelý politicky aktivní na internetu?",
 		Polish:  "Czy podejrzany jest aktywny politycznie w Internecie?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "00ea2e89-4876-4155-8451-a78245a038cc",
 		English: "Does the suspect have low self-esteem?",
 		Czech:   "Má podezřelý nízké sebevědomí?",
 		Polish:  "Czy podejrzany ma niską samoocenę?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "3fb0e1d4-e505-4863-82f0-e90d8dd2c0b5",
 		English: "Does the suspect belong to a religious organization?",
 		Czech:   "Patří podezřelý k náboženské organizaci?",
 		Polish:  "Czy podejrzany należy do organizacji religijnej?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "a762f457-85e2-42d3-943d-2106b3545e31",
 		English: "Does the suspect discuss politics frequently?",
 		Czech:   "Diskutuje podezřelý často o politice?",
 		Polish:  "Czy podejrzany często dyskutuje o polityce?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "bfff6876-1c5c-43cf-b8b4-bc1b441197fc",
 		English: "Is the suspect involved in charity work?",
 		Czech:   "Podílí se podezřelý na charitativní činnosti?",
 		Polish:  "Czy podejrzany jest zaangażowany w działalność charytatywną?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "83e721a1-8078-4985-8f73-384e77cbbf2e",
 		English: "Is the suspect a pet owner?",
 		Czech:   "Má podezřelý domácího mazlíčka?",
 		Polish:  "Czy podejrzany jest właścicielem zwierzęcia?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "09752a05-952e-4968-88f1-b5e36e79762f",
 		English: "Does the suspect experience panic attacks?",
 		Czech:   "Mívá podezřelý panické ataky?",
 		Polish:  "",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "403f68bb-a4d6-4406-9aed-a8dac753cae6",
 		English: "Is the suspect active in a subculture?",
 		Czech:   "Je podezřelý aktivní v některé subkultuře?",
 		Polish:  "Czy podejrzany doświadcza ataków paniki?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "51fb470b-1f43-404a-829c-eaba67d5cad1",
 		English: "Does the suspect enjoy classical music?",
 		Czech:   "Má podezřelý rád klasickou hudbu?",
 		Polish:  "Czy podejrzany lubi muzykę klasyczną?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "07f9f44b-34cd-4081-a34f-e77cd9054c54",
 		English: "Does the suspect practice positive affirmations?",
 		Czech:   "Praktikuje podezřelý pozitivní afirmace?",
 		Polish:  "Czy podejrzany praktykuje pozytywne afirmacje?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "2ad5a101-1f7d-4677-a832-ebfd4585f2db",
 		English: "Does the suspect have many friends?",
 		Czech:   "Má podezřelý hodně přátel?",
 		Polish:  "Czy podejrzany ma wielu przyjaciół?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "39e7ac20-10cf-4df8-a2c7-19b372d463b0",
 		English: "Does the suspect enjoy fast food?",
 		Czech:   "Má podezřelý rád rychlé občerstvení?",
 		Polish:  "Czy podejrzany lubi fast foody?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "b0530b5b-ccae-4628-9f97-2c84611b43b6",
 		English: "Does the suspect support LGBTQ+ rights?",
 		Czech:   "Podporuje podezřelý práva LGBTQ+ lidí?",
 		Polish:  "Czy podejrzany wspiera prawa osób LGBTQ+?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "783afa25-ddfc-4867-a039-a6faca848cf0",
 		English: "Does the suspect watch reality TV?",
 		Czech:   "Sleduje podezřelý reality show?",
 		Polish:  "Czy podejrzany ogląda reality TV?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "e22cbe3f-eeb3-418e-b690-f9cd2d6a871e",
 		English: "Does the suspect believe in socialism?",
 		Czech:   "Je podezřelý zastáncem socialismu?",
 		Polish:  "Czy podejrzany wierzy w socjalizm?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "18afd166-271e-4f30-ba35-2ebaaca6b832",
 		English: "Does the suspect engage in self-care?",
 		Czech:   "Pečuje podezřelý o sebe?",
 		Polish:  "Czy podejrzany angażuje się w samoopiekę?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "08f4fb30-e509-48d1-86db-2c7d017e5b5b",
 		English: "Is the suspect socially awkward?",
 		Czech:   "Je podezřelý společensky neohrabaný?",
 		Polish:  "Czy podejrzany jest niezręczny społecznie?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "52dac77e-0b43-41d9-9212-1058d6880efe",
 		English: "Does the suspect align with feminist ideals?",
 		Czech:   "Je podezřelý v souladu s feministickými ideály?",
 		Polish:  "Czy podejrzany jest zgodny z feministycznymi ideałami?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "33738595-841e-48eb-b2d8-aaccb3a8fbc6",
 		English: "Does the suspect have trust issues?",
 		Czech:   "Má podezřelý problémy s důvěrou?",
 		Polish:  "Czy podejrzany ma problemy z zaufaniem?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "17ec7ea2-f62e-4065-bdab-25f54cefa2fc",
 		English: "Does the suspect drink alcohol?",
 		Czech:   "Pije podezřelý alkohol?",
 		Polish:  "Czy podejrzany pije alkohol?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "dc2c030e-8eb5-434d-b048-4736fb4dcd4e",
 		English: "Does the suspect have anger issues?",
 		Czech:   "Má podezřelý problémy se vztekem?",
 		Polish:  "Czy podejrzany ma problemy z gniewem?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "5e99fe99-32d6-477f-af04-43175d5b6fae",
 		English: "Does the suspect regularly attend social events?",
 		Czech:   "Navštěvuje podezřelý pravidelně společenské akce?",
 		Polish:  "Czy podejrzany regularnie uczestniczy w wydarzeniach towarzyskich?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "f71e092f-f373-4b0b-b13b-d841b3016064",
 		English: "Does the suspect enjoy gardening?",
 		Czech:   "Pracuje podezřelý rád na zahradě?",
 		Polish:  "Czy podejrzany lubi ogrodnictwo?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "ab74953e-f710-4478-a382-a309202095e0",
 		English: "Does the suspect have a fear of failure?",
 		Czech:   "Má podezřelý strach ze selhání?",
 		Polish:  "Czy podejrzany obawia się porażki?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "d198d553-2d92-4dfb-bef8-34a5dfbc871c",
 		English: "Is the suspect a fan of horror movies?",
 		Czech:   "Je podezřelý fanouškem hororů?",
 		Polish:  "Czy podejrzany jest fanem horrorów?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "c173de12-1591-45ac-b4d9-6fb03524e0a4",
 		English: "Does the suspect believe in capitalism?",
 		Czech:   "Věří podezřelý v kapitalismus?",
 		Polish:  "Czy podejrzany wierzy w kapitalizm?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "b912cb24-b07d-47e9-a4de-fcfb65606b63",
 		English: "Does the suspect enjoy fine dining?",
 		Czech:   "Má podezřelý rád dobré jídlo?",
 		Polish:  "Czy podejrzany lubi dobrze zjeść?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "5e11d0d6-0d89-4442-b20b-53cf03ecc12a",
 		English: "Does the suspect support authoritarianism?",
 		Czech:   "Podporuje podezřelý autoritářství?",
 		Polish:  "Czy podejrzany sympatyzuje z autorytaryzmem?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "283a5e3f-9f4f-4c01-8769-4ed4b15b29bb",
 		English: "Does the suspect feel isolated?",
 		Czech:   "Cítí se podezřelý izolovaný?",
 		Polish:  "Czy podejrzany czuje się odizolowany?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "7939babb-96fe-4534-8246-4b028edea54f",
 		English: "Does the suspect collect anything?",
 		Czech:   "Sbírá podezřelý něco?",
 		Polish:  "Czy podejrzany coś zbiera?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "345861e7-4ad0-4a40-b8a1-6d0516fa4454",
 		English: "Does the suspect support progressive taxation?",
 		Czech:   "Podporuje podezřelý progresivní zdanění?",
 		Polish:  "Czy podejrzany popiera progresywne opodatkowanie?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "bfb0a998-8523-458e-91a9-e58f072e9b59",
 		English: "Does the suspect struggle with self-doubt?",
 		Czech:   "Bojuje podezřelý s pochybnostmi o sobě samém?",
 		Polish:  "Czy podejrzany zmaga się z wątpliwościami?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "d1562cfe-64f3-4031-8a75-dacdd1ca36ee",
 		English: "Does the suspect support universal basic income?",
 		Czech:   "Podporuje podezřelý univerzální základní příjem?",
 		Polish:  "",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "89b08a0e-290a-4173-aeaa-5885ea03f9ed",
 		English: "Does the suspect deal with imposter syndrome?",
 		Czech:   "Trpí podezřelý syndromem podvodníka?",
 		Polish:  "Czy podejrzany popiera uniwersalny dochód podstawowy?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "2f04484e-2939-489e-8386-130888069a1c",
 		English: "Is the suspect well-connected in their neighborhood?",
 		Czech:   "Má podezřelý ve svém okolí dobré kontakty?",
 		Polish:  "Czy podejrzany ma dobre kontakty w swojej okolicy?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "e78e0ff5-da67-4bcc-9c88-39ce3c287663",
 		English: "Does the suspect prefer cats?",
 		Czech:   "Má podezřelý raději kočky?",
 		Polish:  "Czy podejrzany preferuje koty?",
 		Topic:   "basic", Level: 1},
 	{
+		UUID:    "ba8e836b-d702-48f0-96fd-88fe0165f9c7",
 		English: "Does the suspect regularly journal?",
 		Czech:   "Píše si podezřelý pravidelně deník?",
 		Polish:  "Czy podejrzany regularnie prowadzi dziennik?",
 		Topic:   "psychological", Level: 1},
 	{
+		UUID:    "f39486a7-96ba-4cd3-b662-a46cdaaa6958",
 		English: "Is the suspect engaged in social justice movements?",
 		Czech:   "Je podezřelý zapojen do hnutí za sociální spravedlnost?",
 		Polish:  "Czy podejrzany jest zaangażowany w ruchy na rzecz sprawiedliwości społecznej?",
 		Topic:   "sociological", Level: 1},
 	{
+		UUID:    "f3d8ed1a-9805-479d-8378-a9a2700f9776",
 		English: "Has the suspect ever tried drugs?",
 		Czech:   "Zkusil podezřelý někdy drogy?",
 		Polish:  "Czy podejrzany kiedykolwiek próbował narkotyków?",
 		Topic:   "political", Level: 1},
 	{
+		UUID:    "99f12498-6a0e-4f06-b4b9-090e21bcf9eb",
 		English: "Does the suspect believe in global climate change?",
 		Czech:   "Věří podezřelý v globální změnu klimatu?",
 		Polish:  "Czy podejrzany wierzy w globalne zmiany klimatu?",
 		Topic:   "ecology", Level: 1},
 	{
+		UUID:    "0223e3c9-e41d-4bed-9860-4d35d7dcdf3f",
 		English: "Does the suspect like contemporary art?",
 		Czech:   "Má rád podezřelý současné umění?",
 		Polish:  "Czy podejrzany lubi sztukę współczesną?",
 		Topic:   "art", Level: 1},
 }
+
+// Services seeded into new database by SeedContent(). Tokens are never seeded,
+// set them by dev set-token command, environment or config file, see config.go.
+var defaultServices = []Service{
+	{Name: "OpenAI", Type: "API", API_style: sql.NullString{String: "openai", Valid: true}, Active: true},
+	{Name: "Anthropic", Type: "API", API_style: sql.NullString{String: "anthropic", Valid: true}},
+	{Name: "DeepSeek", Type: "API", API_style: sql.NullString{String: "openai", Valid: true}, URL: sql.NullString{String: "https://api.deepseek.com", Valid: true}},
+	{Name: "Ollama", Type: "local", API_style: sql.NullString{String: "ollama", Valid: true}, URL: sql.NullString{String: "http://localhost:11434", Valid: true}},
+}
+
+// Models seeded into new database by SeedContent(). Only the Model which described the seeded suspects is Allowed,
+// others can be allowed by dev model command once they have descriptions or play in vision witness mode.
+var defaultModels = []Model{
+	{Name: "gpt-4o-2024-08-06", Service: "OpenAI", Visual: true, Allowed: true, Historical: true},
+	{Name: "chatgpt-4o-latest", Service: "OpenAI", Visual: true},
+	{Name: "gpt-4o-mini-2024-07-18", Service: "OpenAI", Visual: true},
+	{Name: "claude-3-5-sonnet-20240620", Service: "Anthropic", Visual: true},
+	{Name: "claude-3-haiku-20240307", Service: "Anthropic", Visual: true},
+}
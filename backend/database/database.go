@@ -14,74 +14,88 @@ package database
 import (
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/agajdosi/artificial_suspects/backend/llm"
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Content for existing databases, see sync.go.
+//
 //go:embed default.db
 var defaultDB embed.FS
 
-var database *sql.DB
+var (
+	database        *sql.DB
+	databasePath    string // file of the opened database
+	databaseCreated bool   // the file was just created, so there is nothing to back up
+)
 
 const (
 	defaultPlayerName string = "anonymous"
-	numSuspect        int    = 15 // How many suspects are in one investigation - there were 12 in original board game.
+	DefaultBoardSize  int    = 15 // How many suspects are in one investigation - there were 12 in original board game.
 	emoDB             string = "💾"
 )
 
 // MARK: GENERAL DATABASE
 
-// Ensure that database is ready to be used. First, check if gamesDir exists, if not create it.
-// Then, check if database file exists, if not create it and initialize it.
-// Returns the database connection.
+// Ensure that database is ready to be used: open it by OpenDB(), migrate its schema to the current version
+// by MigrateDB(), fill in the data which the game needs - new database gets the seeded content by SeedContent().
+// Existing database gets the new content of default.db only by dev db sync, see sync.go.
 func EnsureDBAvailable(gameDBPath string) error {
+	if err := OpenDB(gameDBPath); err != nil {
+		return err
+	}
+	if _, err := MigrateDB(); err != nil {
+		return err
+	}
+	if err := ensureDefaultPrompts(); err != nil {
+		return err
+	}
+	if databaseCreated {
+		if err := SeedContent(); err != nil {
+			return err
+		}
+	}
+	return encryptServiceTokens()
+}
+
+// Open the database without changing its schema. First, check if its directory exists, if not create it.
+// Then, check if database file exists, if not create it empty - its schema is made by MigrateDB().
+func OpenDB(gameDBPath string) error {
 	log.Printf("%s Checking the database file at: %s\n", emoDB, gameDBPath)
+	databaseCreated = false
 	_, err := os.Stat(gameDBPath)
-	if os.IsNotExist(err) {
+	create := os.IsNotExist(err)
+	if create {
 		log.Printf("%s Database file %s does not exist, creating it...\n", emoDB, gameDBPath)
 		parentDir := filepath.Dir(gameDBPath)
 		err = os.MkdirAll(parentDir, 0755)
 		if err != nil {
 			return err
 		}
-
-		log.Printf("%s Opening default.db", emoDB)
-		defaultDBFile, err := defaultDB.Open("default.db")
-		if err != nil {
-			return err
-		}
-		defer defaultDBFile.Close()
-
-		log.Printf("%s Creating new database file at %s", emoDB, gameDBPath)
-		newDBFile, err := os.Create(gameDBPath)
-		if err != nil {
-			return err
-		}
-		defer newDBFile.Close()
-
-		log.Printf("%s Copying default.db to %s", emoDB, gameDBPath)
-		_, err = io.Copy(newDBFile, defaultDBFile)
-		if err != nil {
-			return err
-		}
-		log.Printf("%s Database successfully created from default.db!", emoDB)
 	}
 
 	db, err := sql.Open("sqlite3", gameDBPath)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	database = db
+	databasePath = gameDBPath
+	if create {
+		databaseCreated = true
+		log.Printf("%s Database successfully created!", emoDB)
+	}
 	log.Printf("%s Database successfully opened!", emoDB)
-
 	return nil
 }
 
@@ -136,6 +150,11 @@ func GetSuspect(suspectUUID string) (Suspect, error) {
 	return suspect, nil
 }
 
+// Path of the image of the Suspect in the frontend, relative to the backend and dev directories the game runs from.
+func suspectImagePath(suspect Suspect) string {
+	return filepath.Join("..", "front", "static", "suspects", suspect.Image)
+}
+
 // Get all Suspects and their complete data for specified Investigation.
 // It needs Investigation because we need to iterate over its Rounds and Rounds' Eliminations
 // to set Suspect.Free and Suspect.Fled booleans.
@@ -174,7 +193,7 @@ func getSuspectsInInvestigation(suspectUUIDs []string, investigation Investigati
 
 func GetAllSuspects() ([]Suspect, error) {
 	var suspects []Suspect
-	rows, err := database.Query("SELECT uuid, image, timestamp FROM suspects", numSuspect)
+	rows, err := database.Query("SELECT uuid, image, timestamp FROM suspects")
 	if err != nil {
 		log.Printf("Could not get random suspects: %v\n", err)
 		return suspects, err
@@ -222,9 +241,9 @@ func GetSuspectsByDescriptions(limit int, serviceName, modelName string) ([]Susp
 	return suspects, nil
 }
 
-func randomSuspects() ([]Suspect, error) {
+func randomSuspects(count int) ([]Suspect, error) {
 	var suspects []Suspect
-	rows, err := database.Query("SELECT uuid, image, timestamp FROM suspects ORDER BY RANDOM() LIMIT $1", numSuspect)
+	rows, err := database.Query("SELECT uuid, image, timestamp FROM suspects ORDER BY RANDOM() LIMIT $1", count)
 	if err != nil {
 		log.Printf("Could not get random suspects: %v\n", err)
 		return suspects, err
@@ -267,6 +286,8 @@ type Game struct {
 	Investigator  Player        `json:"Investigator"`  // The human player, right now can play only as investigator
 	Timestamp     string        `json:"Timestamp"`     // when game was created
 	Model         string        `json:"Model"`         // LLM model used for generating descriptions and answers
+	WitnessMode   WitnessMode   `json:"WitnessMode"`   // Whether the witness reads Descriptions or sees the portraits
+	BoardSize     int           `json:"BoardSize"`     // Number of Suspects in each Investigation, see BoardSizes
 	Investigation Investigation `json:"investigation"` // TODO: actually this could be Investigations []Investigation
 	Level         int           `json:"level"`         // aka number of Investigations done + 1
 	GameOver      bool          `json:"GameOver"`      // TODO: when true, Game is over
@@ -275,12 +296,31 @@ type Game struct {
 
 // Create a new game for the current player identified by their playerUUID.
 // Multiple players can play the game at the same time, so we need to identify the player by their playerUUID.
-func NewGame(playerUUID, model string) (Game, error) {
+// In WitnessVision mode the model must be visual. boardSize must be one of BoardSizes.
+func NewGame(playerUUID, model string, mode WitnessMode, boardSize int) (Game, error) {
 	var game Game
+	if err := CheckBoardSize(boardSize); err != nil {
+		return game, err
+	}
+	if model != "" {
+		m, err := GetModel(model)
+		if err != nil {
+			return game, err
+		}
+		if err := checkWitnessModel(m, mode); err != nil {
+			return game, err
+		}
+		if err := CheckBudget(m); err != nil {
+			return game, err
+		}
+	}
+
 	game.UUID = uuid.New().String()
 	game.Timestamp = TimestampNow()
 	game.Score = 0
 	game.Model = model
+	game.WitnessMode = mode
+	game.BoardSize = boardSize
 	game.Investigator = Player{
 		UUID: playerUUID,
 		Name: defaultPlayerName, // TODO: also pass from the frontend
@@ -290,7 +330,7 @@ func NewGame(playerUUID, model string) (Game, error) {
 		return game, err
 	}
 
-	game.Investigation, err = NewInvestigation(game.UUID)
+	game.Investigation, err = NewInvestigation(game.UUID, game.BoardSize)
 	if err != nil {
 		return game, err
 	}
@@ -306,13 +346,13 @@ func NewGame(playerUUID, model string) (Game, error) {
 // Multiple players can play the game at the same time, so we need to identify the game by playerUUID.
 func GetCurrentGame(playerUUID string) (Game, error) {
 	var game Game
-	row := database.QueryRow("SELECT uuid, timestamp, score, model FROM games WHERE player_uuid = $1 ORDER BY timestamp DESC LIMIT 1", playerUUID)
-	err := row.Scan(&game.UUID, &game.Timestamp, &game.Score, &game.Model)
+	row := database.QueryRow("SELECT uuid, timestamp, score, model, witness_mode, board_size FROM games WHERE player_uuid = $1 ORDER BY timestamp DESC LIMIT 1", playerUUID)
+	err := row.Scan(&game.UUID, &game.Timestamp, &game.Score, &game.Model, &game.WitnessMode, &game.BoardSize)
 
 	// No game found - first play
 	if err == sql.ErrNoRows {
 		log.Println("Warning: No games in DB, creating new game")
-		return NewGame("", "") // TODO: PlayerUUID should be passed from frontend
+		return NewGame("", "", WitnessDescription, DefaultBoardSize) // TODO: PlayerUUID should be passed from frontend
 	}
 	if err != nil {
 		return game, err
@@ -338,7 +378,7 @@ func GetCurrentGame(playerUUID string) (Game, error) {
 }
 
 func saveGame(game Game) error {
-	query := `INSERT INTO games (uuid, timestamp, score, investigator, player_uuid, model) VALUES (?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO games (uuid, timestamp, score, investigator, player_uuid, model, witness_mode, board_size) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 	_, err := database.Exec(
 		query,
 		game.UUID,
@@ -347,6 +387,8 @@ func saveGame(game Game) error {
 		game.Investigator.Name,
 		game.Investigator.UUID,
 		game.Model,
+		game.WitnessMode,
+		game.BoardSize,
 	)
 	return err
 }
@@ -366,6 +408,16 @@ func isGameOver(game Game) bool {
 
 // MARK: INVESTIGATION
 
+// Board sizes a Game can be played with: 12 like the original board game, 15 by default, or 24.
+var BoardSizes = []int{12, DefaultBoardSize, 24}
+
+func CheckBoardSize(size int) error {
+	if !slices.Contains(BoardSizes, size) {
+		return fmt.Errorf("unsupported board size %d, use one of %v", size, BoardSizes)
+	}
+	return nil
+}
+
 // Investigation is a set of X Suspects, User needs to find a Criminal among them.
 type Investigation struct {
 	UUID              string    `json:"uuid"`
@@ -377,63 +429,46 @@ type Investigation struct {
 	Timestamp         string    `json:"Timestamp"`
 }
 
+// Save the Investigation and its Suspects, the order of Suspects is kept by their position on the board.
 func saveInvestigation(investigation Investigation) error {
-	if len(investigation.Suspects) != 15 {
-		err := fmt.Errorf("Investigation does not have 15 suspects, has %d", (len(investigation.Suspects)))
+	if err := CheckBoardSize(len(investigation.Suspects)); err != nil {
 		log.Printf("Cannot save investigation: %v\n", err)
 		return err
 	}
 
-	query := `INSERT OR REPLACE INTO investigations
-		(uuid, game_uuid, timestamp,
-		sus1_uuid,
-		sus2_uuid,
-		sus3_uuid,
-		sus4_uuid,
-		sus5_uuid,
-		sus6_uuid,
-		sus7_uuid,
-		sus8_uuid,
-		sus9_uuid,
-		sus10_uuid,
-		sus11_uuid,
-		sus12_uuid,
-		sus13_uuid,
-		sus14_uuid,
-		sus15_uuid,
-		criminal_uuid
-		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err := database.Exec(query, investigation.UUID, investigation.GameUUID, investigation.Timestamp,
-		investigation.Suspects[0].UUID,
-		investigation.Suspects[1].UUID,
-		investigation.Suspects[2].UUID,
-		investigation.Suspects[3].UUID,
-		investigation.Suspects[4].UUID,
-		investigation.Suspects[5].UUID,
-		investigation.Suspects[6].UUID,
-		investigation.Suspects[7].UUID,
-		investigation.Suspects[8].UUID,
-		investigation.Suspects[9].UUID,
-		investigation.Suspects[10].UUID,
-		investigation.Suspects[11].UUID,
-		investigation.Suspects[12].UUID,
-		investigation.Suspects[13].UUID,
-		investigation.Suspects[14].UUID,
-		investigation.CriminalUUID,
-	)
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT OR REPLACE INTO investigations (uuid, game_uuid, timestamp, criminal_uuid) VALUES (?, ?, ?, ?)`
+	_, err = tx.Exec(query, investigation.UUID, investigation.GameUUID, investigation.Timestamp, investigation.CriminalUUID)
 	if err != nil {
 		log.Printf("Could not save investigation: %v", err)
 		return err
 	}
 
-	return nil
+	_, err = tx.Exec("DELETE FROM investigation_suspects WHERE investigation_uuid = $1", investigation.UUID)
+	if err != nil {
+		return fmt.Errorf("could not clear suspects of investigation %s: %w", investigation.UUID, err)
+	}
+	for position, suspect := range investigation.Suspects {
+		_, err := tx.Exec("INSERT INTO investigation_suspects (investigation_uuid, suspect_uuid, position) VALUES ($1, $2, $3)",
+			investigation.UUID, suspect.UUID, position+1)
+		if err != nil {
+			log.Printf("Could not save suspect %s of investigation: %v", suspect.UUID, err)
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-// Create a new Investigation, save it into the database and return it.
+// Create a new Investigation with boardSize Suspects, save it into the database and return it.
 // Usage on New Game for initial first Investigation,
 // or when Investigation is successfully solved and we need new one.
-func NewInvestigation(gameUUID string) (Investigation, error) {
+func NewInvestigation(gameUUID string, boardSize int) (Investigation, error) {
 	var i Investigation
 	i.UUID = uuid.New().String()
 	i.GameUUID = gameUUID
@@ -445,10 +480,16 @@ func NewInvestigation(gameUUID string) (Investigation, error) {
 	}
 	i.Rounds = append(i.Rounds, round)
 
-	suspects, err := randomSuspects()
+	if err := CheckBoardSize(boardSize); err != nil {
+		return i, err
+	}
+	suspects, err := randomSuspects(boardSize)
 	if err != nil {
 		return i, err
 	}
+	if len(suspects) < boardSize {
+		return i, fmt.Errorf("board of %d suspects needs more suspects, database has only %d", boardSize, len(suspects))
+	}
 	i.Suspects = suspects
 	cn := rand.IntN(len(suspects))
 	i.CriminalUUID = i.Suspects[cn].UUID
@@ -458,55 +499,46 @@ func NewInvestigation(gameUUID string) (Investigation, error) {
 	return i, err
 }
 
+// UUIDs of the Suspects of the Investigation ordered by their position on the board.
+func getInvestigationSuspectUUIDs(investigationUUID string) ([]string, error) {
+	var suspectUUIDs []string
+	rows, err := database.Query("SELECT suspect_uuid FROM investigation_suspects WHERE investigation_uuid = $1 ORDER BY position", investigationUUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get suspects of investigation %s: %w", investigationUUID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var suspectUUID string
+		if err := rows.Scan(&suspectUUID); err != nil {
+			return nil, fmt.Errorf("could not scan suspect of investigation %s: %w", investigationUUID, err)
+		}
+		suspectUUIDs = append(suspectUUIDs, suspectUUID)
+	}
+	return suspectUUIDs, rows.Err()
+}
+
 func getCurrentInvestigation(gameUUID string) (Investigation, error) {
 	var investigation = Investigation{GameUUID: gameUUID}
-	var suspects_uuids = make([]string, 15)
 	log.Printf("Getting investigation for game %s\n", gameUUID)
-	row := database.QueryRow(`SELECT uuid, timestamp, criminal_uuid,
-		sus1_uuid,
-		sus2_uuid,
-		sus3_uuid,
-		sus4_uuid,
-		sus5_uuid,
-		sus6_uuid,
-		sus7_uuid,
-		sus8_uuid,
-		sus9_uuid,
-		sus10_uuid,
-		sus11_uuid,
-		sus12_uuid,
-		sus13_uuid,
-		sus14_uuid,
-		sus15_uuid
+	row := database.QueryRow(`SELECT uuid, timestamp, criminal_uuid
 		FROM investigations WHERE game_uuid = $1 ORDER BY timestamp DESC LIMIT 1`, gameUUID)
-	err := row.Scan(&investigation.UUID, &investigation.Timestamp, &investigation.CriminalUUID,
-		&suspects_uuids[0],
-		&suspects_uuids[1],
-		&suspects_uuids[2],
-		&suspects_uuids[3],
-		&suspects_uuids[4],
-		&suspects_uuids[5],
-		&suspects_uuids[6],
-		&suspects_uuids[7],
-		&suspects_uuids[8],
-		&suspects_uuids[9],
-		&suspects_uuids[10],
-		&suspects_uuids[11],
-		&suspects_uuids[12],
-		&suspects_uuids[13],
-		&suspects_uuids[14],
-	)
+	err := row.Scan(&investigation.UUID, &investigation.Timestamp, &investigation.CriminalUUID)
 	if err != nil {
 		log.Printf("Could not get investigation: %v\n", err)
 		return investigation, err
 	}
 
+	suspectUUIDs, err := getInvestigationSuspectUUIDs(investigation.UUID)
+	if err != nil {
+		return investigation, err
+	}
+
 	investigation.Rounds, err = getRounds(investigation.UUID)
 	if err != nil {
 		return investigation, err
 	}
 
-	investigation.Suspects, err = getSuspectsInInvestigation(suspects_uuids, investigation)
+	investigation.Suspects, err = getSuspectsInInvestigation(suspectUUIDs, investigation)
 	if err != nil {
 		return investigation, err
 	}
@@ -515,7 +547,7 @@ func getCurrentInvestigation(gameUUID string) (Investigation, error) {
 	for x := range investigation.Rounds {
 		eliminated += len(investigation.Rounds[x].Eliminations)
 	}
-	if eliminated == (numSuspect - 1) {
+	if eliminated == (len(investigation.Suspects) - 1) {
 		investigation.InvestigationOver = true
 	}
 
@@ -528,18 +560,19 @@ type Round struct {
 	UUID              string        `json:"uuid"`
 	InvestigationUUID string        `json:"InvestigationUUID"`
 	Question          Question      `json:"Question"`
-	AnswerUUID        string        `json:"AnswerUUID"`
-	Answer            string        `json:"answer"` // TODO: Answer could be actually stored in table
+	AnswerUUID        string        `json:"AnswerUUID"` // Full Answer with the reflection is in answers table
+	Answer            Decision      `json:"answer"`     // Copy of Answer.Decision, so the game does not need to join answers
+	AnsweredBy        string        `json:"AnsweredBy"` // Model which actually answered, differs from Game.Model if fallback was used
 	Eliminations      []Elimination `json:"Eliminations"`
 	Timestamp         string        `json:"Timestamp"`
 }
 
 func saveRound(r Round) error {
 	query := `
-		INSERT OR REPLACE INTO rounds (uuid, investigation_uuid, question_uuid, answer, timestamp)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT OR REPLACE INTO rounds (uuid, investigation_uuid, question_uuid, answer, answer_uuid, answered_by, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 		`
-	_, err := database.Exec(query, r.UUID, r.InvestigationUUID, r.Question.UUID, r.Answer, r.Timestamp)
+	_, err := database.Exec(query, r.UUID, r.InvestigationUUID, r.Question.UUID, r.Answer, r.AnswerUUID, r.AnsweredBy, r.Timestamp)
 	return err
 }
 
@@ -562,7 +595,7 @@ func getRounds(investigationUUID string) ([]Round, error) {
 	var rounds []Round
 	log.Println("Getting rounds for investigation", investigationUUID)
 
-	rows, err := database.Query("SELECT uuid, investigation_uuid, question_uuid, answer, timestamp FROM rounds WHERE investigation_uuid = $1 ORDER BY timestamp ASC", investigationUUID)
+	rows, err := database.Query("SELECT uuid, investigation_uuid, question_uuid, answer, answer_uuid, answered_by, timestamp FROM rounds WHERE investigation_uuid = $1 ORDER BY timestamp ASC", investigationUUID)
 	if err != nil {
 		log.Printf("Could not get rounds: %v\n", err)
 		return rounds, err
@@ -571,11 +604,14 @@ func getRounds(investigationUUID string) ([]Round, error) {
 
 	for rows.Next() {
 		var round Round
-		err := rows.Scan(&round.UUID, &round.InvestigationUUID, &round.Question.UUID, &round.Answer, &round.Timestamp)
+		err := rows.Scan(&round.UUID, &round.InvestigationUUID, &round.Question.UUID, &round.Answer, &round.AnswerUUID, &round.AnsweredBy, &round.Timestamp)
 		if err != nil {
 			log.Printf("Could not scan round: %v\n", err)
 			return rounds, err
 		}
+		if round.Answer != "" {
+			round.Answer = ParseDecision(string(round.Answer)) // older rounds have free text answers
+		}
 
 		question, err := getQuestion(round.Question.UUID)
 		if err != nil {
@@ -693,6 +729,7 @@ func GetRandomQuestion() (Question, error) {
 }
 
 // English is the cannonical text. If question with same English version exists, it will not overwrite.
+// Question without UUID gets a new random one.
 func SaveQuestion(q Question) error {
 	var exists bool
 	checkQuery := "SELECT EXISTS(SELECT 1 FROM questions WHERE English = ?)"
@@ -705,7 +742,10 @@ func SaveQuestion(q Question) error {
 		return nil
 	}
 
-	UUID := uuid.New().String()
+	UUID := q.UUID
+	if UUID == "" {
+		UUID = uuid.New().String()
+	}
 	query := "INSERT into questions (UUID, English, Czech, Polish, Topic, Level) VALUES (?, ?, ?, ?, ?, ?)"
 	_, err = database.Exec(query, UUID, q.English, q.Czech, q.Polish, q.Topic, q.Level)
 	if err != nil {
@@ -729,35 +769,107 @@ func getQuestion(questionUUID string) (Question, error) {
 
 // MARK: ANSWER
 
+// Answer of the witness to the Question asked in the Round.
+// Besides the Decision it keeps the whole reasoning, so we can show why the witness answered the way it did.
 type Answer struct {
-	UUID      string `json:"UUID"`
-	Text      string `json:"Text"`
-	Timestamp string `json:"Timestamp"`
+	UUID                 string       `json:"UUID"`
+	RoundUUID            string       `json:"RoundUUID"`
+	QuestionUUID         string       `json:"QuestionUUID"`
+	DescriptionUUID      string       `json:"DescriptionUUID"` // Description of the criminal the witness was given
+	SuspectUUID          string       `json:"SuspectUUID"`     // Criminal whose portrait the witness saw, WitnessVision mode only
+	WitnessMode          WitnessMode  `json:"WitnessMode"`
+	CachedFromUUID       string       `json:"CachedFromUUID"` // Original Answer if this one was reused from the cache
+	Service              string       `json:"Service"`
+	Model                string       `json:"Model"`
+	Text                 string       `json:"Text"` // Decision as text, kept for the frontend which translates it
+	Decision             Decision     `json:"Decision"`
+	RawDecision          string       `json:"RawDecision"`          // Decision exactly as the model wrote it
+	Samples              int          `json:"Samples"`              // How many times the model was asked
+	Votes                Votes        `json:"Votes"`                // Distribution of the Decisions, Decision is the majority
+	Reflection           string       `json:"Reflection"`           // Cca 100 words of model thinking about the question
+	ReflectionPrompt     string       `json:"ReflectionPrompt"`     // Prompt as it was sent, rendered from the template
+	ReflectionPromptUUID string       `json:"ReflectionPromptUUID"` // Version of the template in prompts table
+	DecisionPrompt       string       `json:"DecisionPrompt"`
+	DecisionPromptUUID   string       `json:"DecisionPromptUUID"`
+	StartTimestamp       string       `json:"StartTimestamp"` // when generation of the Answer started
+	Timestamp            string       `json:"Timestamp"`      // when the Answer was generated
+	Sampling             llm.Sampling `json:"Sampling"`       // Sampling parameters the Answer was generated with
+	Usage                Usage        `json:"-"`              // Tokens used to generate the Answer, stored in token_usage table
 }
 
-// Save the Answer to the Round record in the database. There is then func WaitForAnswer()
-// which is called from frontend once new Round is found (and so Question can be shown ASAP).
-// But Answer takes time and when it is saved here the WaitForAnswer() retrieves it later.
-func SaveAnswer(answer, roundUUID string) error {
-	query := "UPDATE rounds SET answer = $1 WHERE uuid = $2"
-	result, err := database.Exec(query, answer, roundUUID)
+// Save the Answer into answers table and link it from its Round, Answer.RoundUUID must be set.
+// There is then func WaitForAnswer() which is called from frontend once new Round is found
+// (and so Question can be shown ASAP). But Answer takes time and when it is saved here
+// the WaitForAnswer() retrieves it later.
+func SaveAnswer(answer Answer) error {
+	if answer.RoundUUID == "" {
+		return fmt.Errorf("answer.RoundUUID cannot be empty")
+	}
+	if answer.UUID == "" {
+		answer.UUID = uuid.New().String()
+	}
+
+	query := `INSERT OR REPLACE INTO answers
+		(UUID, RoundUUID, QuestionUUID, DescriptionUUID, SuspectUUID, WitnessMode, CachedFromUUID, Service, Model, Reflection, ReflectionPrompt, DecisionPrompt,
+		ReflectionPromptUUID, DecisionPromptUUID, RawDecision, Decision, Samples, YesVotes, NoVotes, UnparseableVotes,
+		Temperature, TopP, Seed, MaxTokens, StartTimestamp, Timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := database.Exec(query, answer.UUID, answer.RoundUUID, answer.QuestionUUID, answer.DescriptionUUID, answer.SuspectUUID, answer.WitnessMode,
+		answer.CachedFromUUID, answer.Service, answer.Model,
+		answer.Reflection, answer.ReflectionPrompt, answer.DecisionPrompt,
+		answer.ReflectionPromptUUID, answer.DecisionPromptUUID, answer.RawDecision, string(answer.Decision),
+		answer.Samples, answer.Votes.Yes, answer.Votes.No, answer.Votes.Unparseable,
+		answer.Sampling.Temperature, answer.Sampling.TopP, answer.Sampling.Seed, answer.Sampling.MaxTokens,
+		answer.StartTimestamp, answer.Timestamp,
+	)
+	if err != nil {
+		log.Printf("Error saving answer %s for round %s: %v", answer.UUID, answer.RoundUUID, err)
+		return err
+	}
+
+	query = "UPDATE rounds SET answer = $1, answer_uuid = $2, answered_by = $3 WHERE uuid = $4"
+	result, err := database.Exec(query, string(answer.Decision), answer.UUID, answer.Model, answer.RoundUUID)
 	if err != nil {
-		log.Printf("Error updating answer for round %s: %v", roundUUID, err)
+		log.Printf("Error updating answer for round %s: %v", answer.RoundUUID, err)
 		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		log.Printf("Error fetching rows affected for round %s: %v", roundUUID, err)
+		log.Printf("Error fetching rows affected for round %s: %v", answer.RoundUUID, err)
 		return err
 	}
 	if rowsAffected == 0 {
-		log.Printf("No rows were updated for round %s", roundUUID)
+		log.Printf("No rows were updated for round %s", answer.RoundUUID)
 	}
 
 	return nil
 }
 
+// Get the Answer given in the Round.
+func GetAnswerForRound(roundUUID string) (Answer, error) {
+	var answer Answer
+	var decision string
+	query := `SELECT UUID, RoundUUID, QuestionUUID, DescriptionUUID, SuspectUUID, WitnessMode, CachedFromUUID, Service, Model, Reflection, ReflectionPrompt, DecisionPrompt,
+		ReflectionPromptUUID, DecisionPromptUUID, RawDecision, Decision, Samples, YesVotes, NoVotes, UnparseableVotes,
+		Temperature, TopP, Seed, MaxTokens, StartTimestamp, Timestamp
+		FROM answers WHERE RoundUUID = $1 ORDER BY Timestamp DESC LIMIT 1`
+	err := database.QueryRow(query, roundUUID).Scan(&answer.UUID, &answer.RoundUUID, &answer.QuestionUUID, &answer.DescriptionUUID, &answer.SuspectUUID, &answer.WitnessMode,
+		&answer.CachedFromUUID, &answer.Service, &answer.Model,
+		&answer.Reflection, &answer.ReflectionPrompt, &answer.DecisionPrompt,
+		&answer.ReflectionPromptUUID, &answer.DecisionPromptUUID, &answer.RawDecision, &decision,
+		&answer.Samples, &answer.Votes.Yes, &answer.Votes.No, &answer.Votes.Unparseable,
+		&answer.Sampling.Temperature, &answer.Sampling.TopP, &answer.Sampling.Seed, &answer.Sampling.MaxTokens,
+		&answer.StartTimestamp, &answer.Timestamp,
+	)
+	if err != nil {
+		return answer, fmt.Errorf("could not get answer for round %s: %w", roundUUID, err)
+	}
+	answer.Decision = Decision(decision)
+	answer.Text = decision
+	return answer, nil
+}
+
 // MARK: LEVEL & SCORE
 
 func getLevel(gameUUID string) (int, error) {
@@ -854,29 +966,38 @@ func SaveScore(name, gameUUID string) error {
 
 // MARK: AI SERVICES
 
-// Service is an LLM provider. It can be OpenAI, Anthropic, DeepSeek, or local model served via LiteLLM.
+// Service is an LLM provider. It can be OpenAI, Anthropic, DeepSeek, or local model served via Ollama or LiteLLM.
+// Token and URL stored in the database can be overridden by environment variables or config file, see config.go.
 type Service struct {
 	Name      string         `json:"Name"`      // Name presented to the user
-	API_style sql.NullString `json:"API_style"` // What is the style of the API (openai, deepseek, etc) - we can have DeepSeek provided via LiteLLM (which uses openai API style)
-	Type      string         `json:"Type"`      // API or local
+	API_style sql.NullString `json:"API_style"` // What is the style of the API (openai, anthropic, etc) - we can have DeepSeek provided via LiteLLM (which uses openai API style)
+	Type      string         `json:"Type"`      // API or local - local Services do not need Token and default to Ollama API style
 	URL       sql.NullString `json:"URL"`
-	Token     string         `json:"Token"`
+	Token     llm.Secret     `json:"Token"` // Encrypted at rest, always marshalled as redacted
 	Active    bool           `json:"Active"`
+	// Spending caps in USD for all Models of the Service together, 0 means no cap. See budget.go.
+	DailyBudget   float64 `json:"DailyBudget"`
+	MonthlyBudget float64 `json:"MonthlyBudget"`
+	// Parallel describe jobs, see jobs.go, 0 means DefaultServiceConcurrency.
+	Concurrency int `json:"Concurrency"`
+	// Pace of all requests to the Service, see llm.Service. 0 means no rate limit.
+	RequestsPerMinute int `json:"RequestsPerMinute"`
 }
 
 func GetService(name string) (Service, error) {
 	var service Service
-	query := "SELECT Name, API_style, Type, URL, Token, Active FROM services WHERE name = $1"
-	err := database.QueryRow(query, name).Scan(&service.Name, &service.API_style, &service.Type, &service.URL, &service.Token, &service.Active)
+	query := "SELECT Name, API_style, Type, URL, Token, Active, DailyBudget, MonthlyBudget, Concurrency, RequestsPerMinute FROM services WHERE name = $1"
+	err := database.QueryRow(query, name).Scan(&service.Name, &service.API_style, &service.Type, &service.URL, &service.Token, &service.Active, &service.DailyBudget, &service.MonthlyBudget, &service.Concurrency, &service.RequestsPerMinute)
 	if err != nil {
 		return service, fmt.Errorf("error geting Service for name %s: %v", name, err)
 	}
+	applyServiceOverrides(&service)
 	return service, nil
 }
 
 func GetServices() ([]Service, error) {
 	var services []Service
-	query := "SELECT Name, API_style, Type, URL, Token, Active FROM services"
+	query := "SELECT Name, API_style, Type, URL, Token, Active, DailyBudget, MonthlyBudget, Concurrency, RequestsPerMinute FROM services"
 	rows, err := database.Query(query)
 	if err != nil {
 		return services, err
@@ -885,10 +1006,11 @@ func GetServices() ([]Service, error) {
 
 	for rows.Next() {
 		var service Service
-		err := rows.Scan(&service.Name, &service.API_style, &service.Type, &service.URL, &service.Token, &service.Active)
+		err := rows.Scan(&service.Name, &service.API_style, &service.Type, &service.URL, &service.Token, &service.Active, &service.DailyBudget, &service.MonthlyBudget, &service.Concurrency, &service.RequestsPerMinute)
 		if err != nil {
 			return services, err
 		}
+		applyServiceOverrides(&service)
 		services = append(services, service)
 	}
 
@@ -907,22 +1029,77 @@ func GetServiceForModel(modelName string) (Service, error) {
 		return service, fmt.Errorf("could not get model %s for service lookup: %v", modelName, err)
 	}
 
-	query := "SELECT Name, API_style, Type, URL, Token, Active FROM services WHERE name = $1"
+	query := "SELECT Name, API_style, Type, URL, Token, Active, DailyBudget, MonthlyBudget, Concurrency, RequestsPerMinute FROM services WHERE name = $1"
 	row := database.QueryRow(query, model.Service)
-	err = row.Scan(&service.Name, &service.API_style, &service.Type, &service.URL, &service.Token, &service.Active)
+	err = row.Scan(&service.Name, &service.API_style, &service.Type, &service.URL, &service.Token, &service.Active, &service.DailyBudget, &service.MonthlyBudget, &service.Concurrency, &service.RequestsPerMinute)
 	if err != nil {
 		return service, fmt.Errorf("error geting Service for model %s: %v", modelName, err)
 	}
+	applyServiceOverrides(&service)
 
 	return service, nil
 }
 
+// Encrypt the token and store it for the Service. Needs llm.TokenKeyEnv to be set.
+func SetServiceToken(name, token string) error {
+	secret, err := llm.EncryptToken(token)
+	if err != nil {
+		return err
+	}
+	result, err := database.Exec("UPDATE services SET Token = $1 WHERE Name = $2", string(secret), name)
+	if err != nil {
+		return fmt.Errorf("could not set token of service %s: %w", name, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("service %s not found", name)
+	}
+	return nil
+}
+
+// Encrypt tokens which are still stored in plaintext, e.g. in databases from older versions.
+// Without llm.TokenKeyEnv set the tokens are left as they are, only a warning is logged.
+func encryptServiceTokens() error {
+	rows, err := database.Query("SELECT Name, Token FROM services WHERE Token IS NOT NULL AND Token != ''")
+	if err != nil {
+		return fmt.Errorf("could not get service tokens: %w", err)
+	}
+	type plaintext struct{ name, token string }
+	var plaintexts []plaintext
+	for rows.Next() {
+		var name string
+		var token llm.Secret
+		if err := rows.Scan(&name, &token); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not scan service token: %w", err)
+		}
+		if !token.Encrypted() {
+			// Plaintext Secret of our own database, the only place outside llm package where it is read.
+			plaintexts = append(plaintexts, plaintext{name, string(token)})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range plaintexts {
+		if os.Getenv(llm.TokenKeyEnv) == "" {
+			log.Printf("⚠️  Token of service %s is stored in plaintext, set %s to encrypt it\n", p.name, llm.TokenKeyEnv)
+			continue
+		}
+		if err := SetServiceToken(p.name, p.token); err != nil {
+			return err
+		}
+		log.Printf("%s Encrypted token of service %s", emoDB, p.name)
+	}
+	return nil
+}
+
 // Wait until non-empty Answer appears on the Round record in Rounds table.
-// Timeouts in 60 seconds, retries every 1 second. Answer is not modified anyhow,
-// needs to be handled after return. On error returned answer is "". On error during
-// generation of the answer, it is something like "failed OpenAI()". If everything was
-// ok, the answer should be YES or NO, or lowercase variant - parse it later!
-func WaitForAnswer(roundUUID string) string {
+// Timeouts in 60 seconds, retries every 1 second. On error or timeout returned Decision is "".
+// Otherwise it is the Decision saved by SaveAnswer(), answers saved as free text by older
+// versions are parsed by ParseDecision().
+func WaitForAnswer(roundUUID string) Decision {
 	pollInterval := 1 * time.Second
 	timeout := 60 * time.Second
 	start := time.Now()
@@ -938,7 +1115,7 @@ func WaitForAnswer(roundUUID string) string {
 			log.Printf("Answer is still empty, lets sleep for a while...")
 		} else {
 			log.Printf("Answer found: %s", answer)
-			return answer
+			return ParseDecision(answer)
 		}
 		if time.Since(start) > timeout {
 			log.Printf("timed out waiting for answer to be available on Round (%s)\n", roundUUID)
@@ -951,14 +1128,25 @@ func WaitForAnswer(roundUUID string) string {
 // MARK: AI MODELS
 
 type Model struct {
-	Name       string `json:"Name"`
-	Service    string `json:"Service"`    // Service  which provides this model (OpenAI, Anthropic, DeepSeek)
-	Visual     bool   `json:"Visual"`     // Model has visual capabilities
-	Allowed    bool   `json:"Allowed"`    // Model can be used to play the Game right now
-	Historical bool   `json:"Historical"` // Model can be shown in the historical statistics
+	Name        string  `json:"Name"`
+	Service     string  `json:"Service"`     // Service  which provides this model (OpenAI, Anthropic, DeepSeek)
+	Visual      bool    `json:"Visual"`      // Model has visual capabilities
+	Allowed     bool    `json:"Allowed"`     // Model can be used to play the Game right now
+	Historical  bool    `json:"Historical"`  // Model can be shown in the historical statistics
+	Price       float64 `json:"Price"`       // USD per 1M tokens of any kind, used when InputPrice and OutputPrice are not set
+	InputPrice  float64 `json:"InputPrice"`  // USD per 1M prompt tokens, used to compute cost of the calls
+	OutputPrice float64 `json:"OutputPrice"` // USD per 1M completion tokens
+	// Spending caps in USD of this Model, 0 means no cap. Model over the cap is not Allowed until the window resets.
+	DailyBudget   float64 `json:"DailyBudget"`
+	MonthlyBudget float64 `json:"MonthlyBudget"`
+	// Models to answer instead, in this order, when this one fails. Stored comma separated.
+	Fallbacks []string `json:"Fallbacks"`
+	// Sent with every request of this Model, unset parameters are left to the provider.
+	Sampling llm.Sampling `json:"Sampling"`
 }
 
 // Get all available Models from the database.
+// Models which reached their spending cap are returned with Allowed set to false.
 func GetModels(allowedOnly bool, orderBy string) ([]Model, error) {
 	var models []Model
 	var query string
@@ -974,7 +1162,8 @@ func GetModels(allowedOnly bool, orderBy string) ([]Model, error) {
 		where = "WHERE Allowed = 1"
 	}
 
-	query = fmt.Sprintf("SELECT Name, Service, Visual, Allowed, Historical FROM models %s %s", where, order)
+	query = fmt.Sprintf(`SELECT Name, Service, Visual, Allowed, Historical, COALESCE(price, 0), InputPrice, OutputPrice, DailyBudget, MonthlyBudget, Fallbacks,
+		Temperature, TopP, Seed, MaxTokens FROM models %s %s`, where, order)
 
 	fmt.Println("QUERY:", query)
 	rows, err := database.Query(query)
@@ -985,53 +1174,179 @@ func GetModels(allowedOnly bool, orderBy string) ([]Model, error) {
 
 	for rows.Next() {
 		var model Model
-		err := rows.Scan(&model.Name, &model.Service, &model.Visual, &model.Allowed, &model.Historical)
+		var fallbacks string
+		err := rows.Scan(&model.Name, &model.Service, &model.Visual, &model.Allowed, &model.Historical, &model.Price, &model.InputPrice, &model.OutputPrice, &model.DailyBudget, &model.MonthlyBudget, &fallbacks,
+			&model.Sampling.Temperature, &model.Sampling.TopP, &model.Sampling.Seed, &model.Sampling.MaxTokens)
 		if err != nil {
 			return models, err
 		}
+		model.Fallbacks = splitModelNames(fallbacks)
 		models = append(models, model)
 	}
 
 	if err = rows.Err(); err != nil {
 		return models, err
 	}
-	return models, nil
+	rows.Close()
+
+	// Models over their budget are not Allowed until the window resets.
+	var withinBudget []Model
+	for _, model := range models {
+		if model.Allowed {
+			if err := CheckBudget(model); errors.Is(err, ErrBudgetExceeded) {
+				model.Allowed = false
+			} else if err != nil {
+				log.Printf("Could not check budget of model %s: %v\n", model.Name, err)
+			}
+		}
+		if allowedOnly && !model.Allowed {
+			continue
+		}
+		withinBudget = append(withinBudget, model)
+	}
+	return withinBudget, nil
 }
 
 // Get Model specified by its name from the database.
 func GetModel(name string) (Model, error) {
 	var model Model
-	query := "SELECT Name, Service, Visual, Allowed, Historical FROM models WHERE Name = $1"
-	err := database.QueryRow(query, name).Scan(&model.Name, &model.Service, &model.Visual, &model.Allowed, &model.Historical)
+	var fallbacks string
+	query := `SELECT Name, Service, Visual, Allowed, Historical, COALESCE(price, 0), InputPrice, OutputPrice, DailyBudget, MonthlyBudget, Fallbacks,
+		Temperature, TopP, Seed, MaxTokens FROM models WHERE Name = $1`
+	err := database.QueryRow(query, name).Scan(&model.Name, &model.Service, &model.Visual, &model.Allowed, &model.Historical, &model.Price, &model.InputPrice, &model.OutputPrice, &model.DailyBudget, &model.MonthlyBudget, &fallbacks,
+		&model.Sampling.Temperature, &model.Sampling.TopP, &model.Sampling.Seed, &model.Sampling.MaxTokens)
 	if err != nil {
 		return model, fmt.Errorf("error geting Model for name %s: %v", name, err)
 	}
+	model.Fallbacks = splitModelNames(fallbacks)
 	return model, nil
 }
 
+// Get names of the Models to try for answering: the Model itself followed by its Fallbacks.
+// Fallbacks missing in the database are skipped, each Model is listed only once.
+func GetModelChain(name string) ([]string, error) {
+	model, err := GetModel(name)
+	if err != nil {
+		return nil, err
+	}
+	chain := []string{model.Name}
+	for _, fallback := range model.Fallbacks {
+		if slices.Contains(chain, fallback) {
+			continue
+		}
+		if _, err := GetModel(fallback); err != nil {
+			log.Printf("⚠️  Skipping fallback %s of model %s: %v\n", fallback, name, err)
+			continue
+		}
+		chain = append(chain, fallback)
+	}
+	return chain, nil
+}
+
+// Set the Fallbacks of the Model, they are tried in the given order.
+func SetModelFallbacks(name string, fallbacks []string) error {
+	for _, fallback := range fallbacks {
+		if fallback == name {
+			return fmt.Errorf("model %s cannot be its own fallback", name)
+		}
+		if _, err := GetModel(fallback); err != nil {
+			return err
+		}
+	}
+	result, err := database.Exec("UPDATE models SET Fallbacks = $1 WHERE Name = $2", strings.Join(fallbacks, ","), name)
+	if err != nil {
+		return fmt.Errorf("could not set fallbacks of model %s: %w", name, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("model %s not found", name)
+	}
+	return nil
+}
+
+// Set what the Model can do, see Model.Visual, Model.Allowed and Model.Historical.
+func SetModelFlags(name string, visual, allowed, historical bool) error {
+	result, err := database.Exec("UPDATE models SET Visual = $1, Allowed = $2, Historical = $3 WHERE Name = $4",
+		visual, allowed, historical, name)
+	if err != nil {
+		return fmt.Errorf("could not set flags of model %s: %w", name, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("model %s not found", name)
+	}
+	return nil
+}
+
+// Set the prices of the Model in USD per 1M tokens, see Model.Price, Model.InputPrice and Model.OutputPrice.
+func SetModelPrices(name string, price, inputPrice, outputPrice float64) error {
+	if price < 0 || inputPrice < 0 || outputPrice < 0 {
+		return fmt.Errorf("prices of model %s cannot be negative", name)
+	}
+	result, err := database.Exec("UPDATE models SET price = $1, InputPrice = $2, OutputPrice = $3 WHERE Name = $4",
+		price, inputPrice, outputPrice, name)
+	if err != nil {
+		return fmt.Errorf("could not set prices of model %s: %w", name, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("model %s not found", name)
+	}
+	return nil
+}
+
+// Set the sampling parameters of the Model. Nil parameters are left to the provider's default.
+func SetModelSampling(name string, sampling llm.Sampling) error {
+	result, err := database.Exec("UPDATE models SET Temperature = $1, TopP = $2, Seed = $3, MaxTokens = $4 WHERE Name = $5",
+		sampling.Temperature, sampling.TopP, sampling.Seed, sampling.MaxTokens, name)
+	if err != nil {
+		return fmt.Errorf("could not set sampling of model %s: %w", name, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("model %s not found", name)
+	}
+	return nil
+}
+
+func splitModelNames(text string) []string {
+	var names []string
+	for _, name := range strings.Split(text, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // MARK: DESCRIPTIONS
 
 // Holds description of the Suspect image. There can be multiple descriptions for one Suspect.
 // Descriptions can be made by different Services and different Models.
 type Description struct {
-	UUID        string `json:"UUID"`
-	SuspectUUID string `json:"SuspectUUID"`
-	Service     string `json:"Service"`
-	Model       string `json:"Model"`
-	Description string `json:"Description"`
-	Prompt      string `json:"Prompt"`
-	Timestamp   string `json:"Timestamp"`
+	UUID          string `json:"UUID"`
+	SuspectUUID   string `json:"SuspectUUID"`
+	Service       string `json:"Service"`
+	Model         string `json:"Model"`         // Model we asked for
+	ReportedModel string `json:"ReportedModel"` // Exact Model identifier the Service reported back, e.g. dated version of an alias
+	Description   string `json:"Description"`
+	Prompt        string `json:"Prompt"`     // Prompt as it was sent, rendered from the template
+	PromptUUID    string `json:"PromptUUID"` // Version of the prompt template in prompts table
+	Timestamp     string `json:"Timestamp"`
+	// Description failed the validation (refusal, too short, wrong language), it is kept but never given to the witness.
+	Rejected        bool         `json:"Rejected"`
+	RejectionReason string       `json:"RejectionReason"`
+	Sampling        llm.Sampling `json:"Sampling"` // Sampling parameters the Description was generated with
+	Usage           Usage        `json:"-"`        // Tokens used to generate the Description, stored in token_usage table
 }
 
 func SaveDescription(d Description) error {
 	query := `
-		INSERT OR REPLACE INTO descriptions (UUID, SuspectUUID, Service, Model, Description, Prompt, Timestamp)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`
+		INSERT OR REPLACE INTO descriptions (UUID, SuspectUUID, Service, Model, ReportedModel, Description, Prompt, PromptUUID,
+		Rejected, RejectionReason, Temperature, TopP, Seed, MaxTokens, Timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	timestamp := TimestampNow()
 	if d.UUID == "" {
 		d.UUID = uuid.New().String()
 	}
-	_, err := database.Exec(query, d.UUID, d.SuspectUUID, d.Service, d.Model, d.Description, d.Prompt, timestamp)
+	_, err := database.Exec(query, d.UUID, d.SuspectUUID, d.Service, d.Model, d.ReportedModel, d.Description, d.Prompt, d.PromptUUID, d.Rejected, d.RejectionReason,
+		d.Sampling.Temperature, d.Sampling.TopP, d.Sampling.Seed, d.Sampling.MaxTokens, timestamp)
 	return err
 }
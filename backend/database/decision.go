@@ -0,0 +1,80 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// Decision of the witness on the question. Stored in rounds.answer and sent to the frontend,
+// which translates it, so the values must stay lowercase and match the locale keys.
+type Decision string
+
+const (
+	DecisionYes         Decision = "yes"
+	DecisionNo          Decision = "no"
+	DecisionUnparseable Decision = "unparseable" // Model wrote something else than YES or NO
+)
+
+// Parse the raw decision text of the model into Decision.
+// Handles structured output {"answer": "YES"}, casing, punctuation and extra words
+// like "Yes, the suspect probably does." If both or none of YES and NO are found, the
+// decision is DecisionUnparseable - we do not want to guess on behalf of the witness.
+func ParseDecision(raw string) Decision {
+	text := strings.TrimSpace(raw)
+	var structured struct {
+		Answer string `json:"answer"`
+	}
+	if strings.HasPrefix(text, "{") && json.Unmarshal([]byte(text), &structured) == nil {
+		text = structured.Answer
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(words) == 0 {
+		return DecisionUnparseable
+	}
+
+	// The prompt asks for YES or NO only, so the first word is the most reliable.
+	switch words[0] {
+	case "yes":
+		return DecisionYes
+	case "no":
+		return DecisionNo
+	}
+
+	var yes, no bool
+	for _, word := range words {
+		switch word {
+		case "yes":
+			yes = true
+		case "no":
+			no = true
+		}
+	}
+	switch {
+	case yes && !no:
+		return DecisionYes
+	case no && !yes:
+		return DecisionNo
+	default:
+		return DecisionUnparseable
+	}
+}
+
+// Valid Decision is YES or NO, unparseable one is not.
+func (d Decision) Valid() bool {
+	return d == DecisionYes || d == DecisionNo
+}
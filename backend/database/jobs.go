@@ -0,0 +1,342 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// Describe jobs are stored in describe_jobs table, so the run survives crash or Ctrl+C
+// and continues where it stopped when RunDescribeJobs() is called again.
+const (
+	JobPending string = "pending"
+	JobRunning string = "running"
+	JobDone    string = "done"
+	JobFailed  string = "failed"
+)
+
+// Tunables of the describe job queue.
+var (
+	DescribeJobMaxAttempts    = 3 // Failed job is retried on the next run until it fails this many times
+	DefaultServiceConcurrency = 2 // Parallel requests to the Service which does not set its Concurrency
+)
+
+// Task to generate one Description of the Suspect by the Model.
+type DescribeJob struct {
+	UUID        string `json:"UUID"`
+	SuspectUUID string `json:"SuspectUUID"`
+	Model       string `json:"Model"`
+	Status      string `json:"Status"`
+	Attempts    int    `json:"Attempts"`
+	Error       string `json:"Error"` // Error of the last attempt
+	Created     string `json:"Created"`
+	Timestamp   string `json:"Timestamp"` // Last change of the Status
+}
+
+// State of the run, passed to the progress callback after each finished job.
+type JobProgress struct {
+	Total  int
+	Done   int
+	Failed int
+	Paused int            // Jobs left pending because their Service was paused, see pausesService()
+	Errors map[string]int // Error message -> how many jobs failed with it
+}
+
+// Create pending jobs for the Suspects which have less than limit Descriptions by the Model.
+// Descriptions already queued by earlier runs count too, so calling it again does not pile up jobs.
+// Returns number of new jobs.
+func EnqueueDescribeJobs(modelName string, limit int) (int, error) {
+	model, err := GetModel(modelName)
+	if err != nil {
+		return 0, err
+	}
+	if !model.Visual {
+		return 0, fmt.Errorf("model %s is not visual, cannot describe images", modelName)
+	}
+
+	suspects, err := GetAllSuspects()
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, suspect := range suspects {
+		descriptions, err := GetDescriptionsForSuspect(suspect.UUID, modelName, true) // strictly get only descriptions for this model
+		if err != nil {
+			return created, fmt.Errorf("could not check existing descriptions for suspect %s: %w", suspect.UUID, err)
+		}
+		var queued int
+		query := "SELECT COUNT(*) FROM describe_jobs WHERE SuspectUUID = $1 AND Model = $2 AND Status IN ($3, $4, $5)"
+		err = database.QueryRow(query, suspect.UUID, modelName, JobPending, JobRunning, JobBatched).Scan(&queued)
+		if err != nil {
+			return created, fmt.Errorf("could not count queued jobs for suspect %s: %w", suspect.UUID, err)
+		}
+
+		for i := len(descriptions) + queued; i < limit; i++ {
+			now := TimestampNow()
+			_, err := database.Exec(`INSERT INTO describe_jobs (UUID, SuspectUUID, Model, Status, Attempts, Error, Created, Timestamp)
+				VALUES ($1, $2, $3, $4, 0, '', $5, $5)`, uuid.New().String(), suspect.UUID, modelName, JobPending, now)
+			if err != nil {
+				return created, fmt.Errorf("could not enqueue job for suspect %s: %w", suspect.UUID, err)
+			}
+			created++
+		}
+	}
+	return created, nil
+}
+
+// Run pending describe jobs of the Model (all Models if empty) with at most workers jobs in parallel.
+// Each Service additionally limits the run by its Concurrency, its RequestsPerMinute paces every request, see llm.Service.
+// Jobs left running by interrupted run and failed jobs with attempts left are picked up again.
+// Service which cannot take more calls (open circuit breaker or spending cap) is paused for the rest of the run,
+// its jobs stay pending without using up an attempt.
+// Cancelling ctx stops starting new jobs, running ones are finished and saved.
+// onProgress, if set, is called after each finished job.
+func RunDescribeJobs(ctx context.Context, modelName string, workers int, onProgress func(job DescribeJob, progress JobProgress)) (JobProgress, error) {
+	progress := JobProgress{Errors: make(map[string]int)}
+	if workers < 1 {
+		return progress, fmt.Errorf("number of workers must be at least 1, got %d", workers)
+	}
+
+	if err := resetInterruptedJobs(modelName); err != nil {
+		return progress, err
+	}
+
+	jobs, err := getDescribeJobs(modelName, JobPending)
+	if err != nil {
+		return progress, err
+	}
+	progress.Total = len(jobs)
+
+	// Jobs of each Service are dispatched by their own goroutine, so slow or rate limited Service does not block the others.
+	byService := make(map[string][]DescribeJob)
+	for _, job := range jobs {
+		service, err := GetServiceForModel(job.Model)
+		if err != nil {
+			return progress, err
+		}
+		byService[service.Name] = append(byService[service.Name], job)
+	}
+
+	var mu sync.Mutex // guards progress
+	finish := func(job DescribeJob, jobErr error) {
+		job.Attempts++
+		job.Status = JobDone
+		job.Error = ""
+		if jobErr != nil {
+			job.Status = JobFailed
+			job.Error = jobErr.Error()
+		}
+		if err := saveDescribeJob(job); err != nil {
+			log.Printf("Could not save describe job %s: %v\n", job.UUID, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if jobErr != nil {
+			progress.Failed++
+			progress.Errors[job.Error]++
+		} else {
+			progress.Done++
+		}
+		if onProgress != nil {
+			onProgress(job, progress)
+		}
+	}
+
+	// Job is not at fault when its Service is paused, it goes back to pending and keeps its attempts.
+	pause := func(job DescribeJob, jobErr error) {
+		job.Status = JobPending
+		job.Error = jobErr.Error()
+		if err := saveDescribeJob(job); err != nil {
+			log.Printf("Could not save describe job %s: %v\n", job.UUID, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		progress.Paused++
+	}
+
+	pool := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for serviceName, serviceJobs := range byService {
+		service, err := GetService(serviceName)
+		if err != nil {
+			return progress, err
+		}
+		limiter := newServiceLimiter(service)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var running sync.WaitGroup
+			defer running.Wait()
+			var paused atomic.Bool
+			for i, job := range serviceJobs {
+				if err := limiter.acquire(ctx); err != nil {
+					return
+				}
+				if paused.Load() {
+					limiter.release()
+					mu.Lock()
+					progress.Paused += len(serviceJobs) - i
+					mu.Unlock()
+					return
+				}
+				select {
+				case pool <- struct{}{}:
+				case <-ctx.Done():
+					limiter.release()
+					return
+				}
+
+				job.Status = JobRunning
+				if err := saveDescribeJob(job); err != nil {
+					log.Printf("Could not save describe job %s: %v\n", job.UUID, err)
+				}
+				running.Add(1)
+				go func() {
+					defer running.Done()
+					defer func() { <-pool }()
+					defer limiter.release()
+					err := GenerateDescription(job.SuspectUUID, job.Model)
+					if pausesService(err) {
+						if !paused.Swap(true) {
+							log.Printf("Pausing describe jobs of service %s for this run: %v\n", serviceName, err)
+						}
+						pause(job, err)
+						return
+					}
+					finish(job, err)
+				}()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return progress, ctx.Err()
+	}
+	return progress, nil
+}
+
+// Get counts of the jobs of the Model (all Models if empty) by their Status.
+func GetDescribeJobCounts(modelName string) (map[string]int, error) {
+	counts := make(map[string]int)
+	rows, err := database.Query("SELECT Status, COUNT(*) FROM describe_jobs WHERE ($1 = '' OR Model = $1) GROUP BY Status", modelName)
+	if err != nil {
+		return counts, fmt.Errorf("could not count describe jobs: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// Error messages of the progress sorted from the most frequent.
+func (p JobProgress) SortedErrors() []string {
+	messages := make([]string, 0, len(p.Errors))
+	for message := range p.Errors {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if p.Errors[messages[i]] != p.Errors[messages[j]] {
+			return p.Errors[messages[i]] > p.Errors[messages[j]]
+		}
+		return messages[i] < messages[j]
+	})
+	return messages
+}
+
+// Errors which say the Service cannot take more calls for now, not that the job failed.
+func pausesService(err error) bool {
+	return errors.Is(err, ErrServiceUnavailable) || errors.Is(err, ErrBudgetExceeded)
+}
+
+// Return jobs left running by interrupted run and failed jobs with attempts left to pending.
+func resetInterruptedJobs(modelName string) error {
+	_, err := database.Exec(`UPDATE describe_jobs SET Status = $1
+		WHERE ($2 = '' OR Model = $2) AND (Status = $3 OR (Status = $4 AND Attempts < $5))`,
+		JobPending, modelName, JobRunning, JobFailed, DescribeJobMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("could not reset interrupted jobs: %w", err)
+	}
+	return nil
+}
+
+func getDescribeJobs(modelName, status string) ([]DescribeJob, error) {
+	var jobs []DescribeJob
+	query := `SELECT UUID, SuspectUUID, Model, Status, Attempts, Error, Created, Timestamp FROM describe_jobs
+		WHERE ($1 = '' OR Model = $1) AND Status = $2 ORDER BY Created, UUID`
+	rows, err := database.Query(query, modelName, status)
+	if err != nil {
+		return nil, fmt.Errorf("could not get describe jobs: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var job DescribeJob
+		err := rows.Scan(&job.UUID, &job.SuspectUUID, &job.Model, &job.Status, &job.Attempts, &job.Error, &job.Created, &job.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan describe job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func saveDescribeJob(job DescribeJob) error {
+	_, err := database.Exec("UPDATE describe_jobs SET Status = $1, Attempts = $2, Error = $3, Timestamp = $4 WHERE UUID = $5",
+		job.Status, job.Attempts, job.Error, TimestampNow(), job.UUID)
+	return err
+}
+
+// MARK: SERVICE LIMITS
+
+// Limits parallel jobs of the Service by Service.Concurrency.
+type serviceLimiter struct {
+	slots chan struct{}
+}
+
+func newServiceLimiter(service Service) *serviceLimiter {
+	concurrency := service.Concurrency
+	if concurrency < 1 {
+		concurrency = DefaultServiceConcurrency
+	}
+	return &serviceLimiter{slots: make(chan struct{}, concurrency)}
+}
+
+// Wait for free slot. Call release() once the job is done.
+func (l *serviceLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *serviceLimiter) release() {
+	<-l.slots
+}
@@ -0,0 +1,185 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Versioned migrations of the schema. Each is applied once, in its own transaction, and recorded
+// in schema_version table. Most are SQL files migrations/<version>_<name>.sql embedded in the binary,
+// the ones which need to check the state of the database first are Go functions in goMigrations.
+// Released migrations must never change - add a new one with the next version instead.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// One step of the schema. Version orders the steps, it is never reused.
+type Migration struct {
+	Version int
+	Name    string
+	Source  string // file of the SQL migration, empty for Go migration
+	up      func(tx *sql.Tx) error
+}
+
+// Go migrations, see schema.go.
+var goMigrations = []Migration{
+	{Version: 2, Name: "legacy_columns", up: migrateLegacyColumns},
+	{Version: 16, Name: "investigation_suspects", up: migrateInvestigationSuspects},
+}
+
+const schemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+	Version INTEGER PRIMARY KEY,
+	Name TEXT NOT NULL,
+	Timestamp TEXT
+)`
+
+// All migrations sorted by version.
+func loadMigrations() ([]Migration, error) {
+	migrations := append([]Migration{}, goMigrations...)
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		base := strings.TrimSuffix(filepath.Base(file), ".sql")
+		prefix, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !found || err != nil || version < 1 {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.sql", file)
+		}
+		query, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			Source:  file,
+			up: func(tx *sql.Tx) error {
+				_, err := tx.Exec(string(query))
+				return err
+			},
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s have the same version %d", migrations[i-1].Name, migrations[i].Name, migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Version of the schema of the opened database, 0 if no migration was applied yet.
+func SchemaVersion() (int, error) {
+	if _, err := database.Exec(schemaVersionTable); err != nil {
+		return 0, fmt.Errorf("could not create schema_version table: %w", err)
+	}
+	var version int
+	err := database.QueryRow("SELECT COALESCE(MAX(Version), 0) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("could not get schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Migrations which are not applied to the opened database yet, in the order they will be applied.
+func PendingMigrations() ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	current, err := SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) > 0 && current > migrations[len(migrations)-1].Version {
+		return nil, fmt.Errorf("database schema version %d is newer than this build knows (%d), update the game",
+			current, migrations[len(migrations)-1].Version)
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Apply the pending migrations to the opened database. Existing database is backed up first
+// next to its file, see BackupDB(). Returns the applied migrations.
+// When a migration fails, it is rolled back and the following ones are not applied.
+func MigrateDB() ([]Migration, error) {
+	pending, err := PendingMigrations()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	if !databaseCreated {
+		from, err := SchemaVersion()
+		if err != nil {
+			return nil, err
+		}
+		backup := fmt.Sprintf("%s.v%d-%s.bak", databasePath, from, time.Now().Format("20060102-150405"))
+		if err := BackupDB(backup); err != nil {
+			return nil, err
+		}
+		log.Printf("%s Database backed up to %s before migrating", emoDB, backup)
+	}
+
+	var applied []Migration
+	for _, m := range pending {
+		log.Printf("%s Applying migration %d %s", emoDB, m.Version, m.Name)
+		if err := applyMigration(m); err != nil {
+			return applied, fmt.Errorf("migration %d %s failed: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// Write consistent copy of the opened database into new file at path.
+func BackupDB(path string) error {
+	if _, err := database.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("could not back up the database to %s: %w", path, err)
+	}
+	return nil
+}
+
+func applyMigration(m Migration) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_version (Version, Name, Timestamp) VALUES ($1, $2, $3)", m.Version, m.Name, TimestampNow())
+	if err != nil {
+		return fmt.Errorf("could not record schema version: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,87 @@
+-- Schema of the default.db shipped before the versioned migrations. Databases copied from it already have
+-- these tables, new database gets them here.
+CREATE TABLE IF NOT EXISTS games (
+	uuid TEXT PRIMARY KEY,
+	score INT,
+	investigator TEXT,
+	timestamp TEXT
+);
+
+CREATE TABLE IF NOT EXISTS investigations (
+	uuid TEXT PRIMARY KEY,
+	game_uuid TEXT,
+	timestamp TEXT,
+	criminal_uuid TEXT,
+	sus1_uuid TEXT,
+	sus2_uuid TEXT,
+	sus3_uuid TEXT,
+	sus4_uuid TEXT,
+	sus5_uuid TEXT,
+	sus6_uuid TEXT,
+	sus7_uuid TEXT,
+	sus8_uuid TEXT,
+	sus9_uuid TEXT,
+	sus10_uuid TEXT,
+	sus11_uuid TEXT,
+	sus12_uuid TEXT,
+	sus13_uuid TEXT,
+	sus14_uuid TEXT,
+	sus15_uuid TEXT
+);
+
+CREATE TABLE IF NOT EXISTS rounds (
+	uuid TEXT PRIMARY KEY,
+	investigation_uuid TEXT,
+	question_uuid TEXT,
+	answer TEXT,
+	timestamp TEXT
+);
+
+CREATE TABLE IF NOT EXISTS eliminations (
+	UUID TEXT PRIMARY KEY,
+	RoundUUID TEXT,
+	SuspectUUID TEXT,
+	Timestamp TEXT
+);
+
+CREATE TABLE IF NOT EXISTS descriptions (
+	UUID TEXT PRIMARY KEY,
+	SuspectUUID TEXT,
+	Service TEXT,
+	Model TEXT,
+	Description TEXT,
+	Prompt TEXT,
+	Timestamp TEXT
+);
+
+CREATE TABLE IF NOT EXISTS questions (
+	UUID TEXT PRIMARY KEY,
+	English TEXT,
+	Czech TEXT,
+	Polish TEXT,
+	Topic TEXT,
+	Level INT
+);
+
+CREATE TABLE IF NOT EXISTS suspects (
+	uuid TEXT PRIMARY KEY,
+	image TEXT,
+	timestamp TEXT
+);
+
+CREATE TABLE IF NOT EXISTS models (
+	Name TEXT PRIMARY KEY,
+	Service TEXT,
+	Active INT
+);
+
+CREATE TABLE IF NOT EXISTS services (
+	Name TEXT,
+	Type TEXT,
+	TextModel TEXT,
+	VisualModel TEXT,
+	Token TEXT,
+	URL TEXT,
+	Active INTEGER,
+	PRIMARY KEY (Name)
+);
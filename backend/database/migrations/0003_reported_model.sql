@@ -0,0 +1,2 @@
+-- Model identifier as reported back by the provider.
+ALTER TABLE descriptions ADD COLUMN ReportedModel TEXT NOT NULL DEFAULT '';
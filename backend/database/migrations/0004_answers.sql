@@ -0,0 +1,16 @@
+-- Answers of the witness with the reflection and the prompts, linked from their round.
+CREATE TABLE answers (
+	UUID TEXT PRIMARY KEY,
+	RoundUUID TEXT,
+	DescriptionUUID TEXT,
+	Service TEXT,
+	Model TEXT,
+	Reflection TEXT,
+	ReflectionPrompt TEXT,
+	DecisionPrompt TEXT,
+	RawDecision TEXT,
+	Decision TEXT,
+	StartTimestamp TEXT,
+	Timestamp TEXT
+);
+ALTER TABLE rounds ADD COLUMN answer_uuid TEXT NOT NULL DEFAULT '';
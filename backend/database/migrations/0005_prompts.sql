@@ -0,0 +1,13 @@
+-- Versioned prompt templates, see prompt.go, and the versions used by each description and answer.
+CREATE TABLE prompts (
+	UUID TEXT PRIMARY KEY,
+	Name TEXT NOT NULL,
+	Version INTEGER NOT NULL,
+	Template TEXT NOT NULL,
+	Active INTEGER NOT NULL DEFAULT 0,
+	Timestamp TEXT,
+	UNIQUE(Name, Version)
+);
+ALTER TABLE descriptions ADD COLUMN PromptUUID TEXT NOT NULL DEFAULT '';
+ALTER TABLE answers ADD COLUMN ReflectionPromptUUID TEXT NOT NULL DEFAULT '';
+ALTER TABLE answers ADD COLUMN DecisionPromptUUID TEXT NOT NULL DEFAULT '';
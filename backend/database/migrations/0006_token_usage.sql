@@ -0,0 +1,15 @@
+-- Tokens and cost of each LLM call, see usage.go. Prices are USD per 1M tokens.
+CREATE TABLE token_usage (
+	UUID TEXT PRIMARY KEY,
+	Kind TEXT,
+	Service TEXT,
+	Model TEXT,
+	ReferenceUUID TEXT,
+	PromptTokens INTEGER,
+	CompletionTokens INTEGER,
+	Cost REAL,
+	Day TEXT,
+	Timestamp TEXT
+);
+ALTER TABLE models ADD COLUMN InputPrice REAL NOT NULL DEFAULT 0;
+ALTER TABLE models ADD COLUMN OutputPrice REAL NOT NULL DEFAULT 0;
@@ -0,0 +1,15 @@
+-- Daily and monthly spending caps in USD, 0 means no cap, see budget.go.
+CREATE TABLE budget_events (
+	Scope TEXT,
+	Name TEXT,
+	Window TEXT,
+	Period TEXT,
+	BudgetLimit REAL,
+	Spent REAL,
+	Timestamp TEXT,
+	PRIMARY KEY (Scope, Name, Window, Period)
+);
+ALTER TABLE models ADD COLUMN DailyBudget REAL NOT NULL DEFAULT 0;
+ALTER TABLE models ADD COLUMN MonthlyBudget REAL NOT NULL DEFAULT 0;
+ALTER TABLE services ADD COLUMN DailyBudget REAL NOT NULL DEFAULT 0;
+ALTER TABLE services ADD COLUMN MonthlyBudget REAL NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Question is part of the answer cache key, reused answer points to the original one, see cache.go.
+ALTER TABLE answers ADD COLUMN QuestionUUID TEXT NOT NULL DEFAULT '';
+ALTER TABLE answers ADD COLUMN CachedFromUUID TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- How many times the model was asked and how the samples voted, see voting.go.
+ALTER TABLE answers ADD COLUMN Samples INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE answers ADD COLUMN YesVotes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE answers ADD COLUMN NoVotes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE answers ADD COLUMN UnparseableVotes INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Comma separated names of the models which answer when the model fails, and the model which actually answered.
+ALTER TABLE models ADD COLUMN Fallbacks TEXT NOT NULL DEFAULT '';
+ALTER TABLE rounds ADD COLUMN answered_by TEXT NOT NULL DEFAULT '';
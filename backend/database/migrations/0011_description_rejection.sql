@@ -0,0 +1,3 @@
+-- Descriptions which failed the validation, see validation.go.
+ALTER TABLE descriptions ADD COLUMN Rejected INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE descriptions ADD COLUMN RejectionReason TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- Queue of descriptions to generate and the limits of the services, see jobs.go.
+CREATE TABLE describe_jobs (
+	UUID TEXT PRIMARY KEY,
+	SuspectUUID TEXT NOT NULL,
+	Model TEXT NOT NULL,
+	Status TEXT NOT NULL,
+	Attempts INTEGER NOT NULL DEFAULT 0,
+	Error TEXT NOT NULL DEFAULT '',
+	Created TEXT,
+	Timestamp TEXT
+);
+ALTER TABLE services ADD COLUMN Concurrency INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE services ADD COLUMN RequestsPerMinute INTEGER NOT NULL DEFAULT 0;
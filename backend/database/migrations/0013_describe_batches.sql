@@ -0,0 +1,13 @@
+-- Describe jobs sent to the Batch API, see batch.go.
+CREATE TABLE describe_batches (
+	ID TEXT PRIMARY KEY,
+	Service TEXT NOT NULL,
+	Model TEXT NOT NULL,
+	Prompt TEXT NOT NULL,
+	PromptUUID TEXT NOT NULL,
+	Status TEXT NOT NULL,
+	Imported INTEGER NOT NULL DEFAULT 0,
+	Created TEXT,
+	Timestamp TEXT
+);
+ALTER TABLE describe_jobs ADD COLUMN BatchID TEXT NOT NULL DEFAULT '';
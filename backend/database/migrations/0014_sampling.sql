@@ -0,0 +1,17 @@
+-- Sampling parameters of the model, and as used for each description and answer. NULL means provider's default.
+ALTER TABLE models ADD COLUMN Temperature REAL;
+ALTER TABLE models ADD COLUMN TopP REAL;
+ALTER TABLE models ADD COLUMN Seed INTEGER;
+ALTER TABLE models ADD COLUMN MaxTokens INTEGER;
+ALTER TABLE descriptions ADD COLUMN Temperature REAL;
+ALTER TABLE descriptions ADD COLUMN TopP REAL;
+ALTER TABLE descriptions ADD COLUMN Seed INTEGER;
+ALTER TABLE descriptions ADD COLUMN MaxTokens INTEGER;
+ALTER TABLE answers ADD COLUMN Temperature REAL;
+ALTER TABLE answers ADD COLUMN TopP REAL;
+ALTER TABLE answers ADD COLUMN Seed INTEGER;
+ALTER TABLE answers ADD COLUMN MaxTokens INTEGER;
+ALTER TABLE describe_batches ADD COLUMN Temperature REAL;
+ALTER TABLE describe_batches ADD COLUMN TopP REAL;
+ALTER TABLE describe_batches ADD COLUMN Seed INTEGER;
+ALTER TABLE describe_batches ADD COLUMN MaxTokens INTEGER;
@@ -0,0 +1,4 @@
+-- Witness answering from the descriptions or from the portrait itself, see witness.go.
+ALTER TABLE games ADD COLUMN witness_mode TEXT NOT NULL DEFAULT 'description';
+ALTER TABLE answers ADD COLUMN WitnessMode TEXT NOT NULL DEFAULT 'description';
+ALTER TABLE answers ADD COLUMN SuspectUUID TEXT NOT NULL DEFAULT ''; -- portrait the witness saw, vision mode only
@@ -0,0 +1,9 @@
+-- Indexes for the lookups done on each round of the game and by the answer cache.
+CREATE INDEX IF NOT EXISTS investigations_game ON investigations (game_uuid);
+CREATE INDEX IF NOT EXISTS rounds_investigation ON rounds (investigation_uuid);
+CREATE INDEX IF NOT EXISTS eliminations_round ON eliminations (RoundUUID);
+CREATE INDEX IF NOT EXISTS answers_round ON answers (RoundUUID);
+CREATE INDEX IF NOT EXISTS answers_cache ON answers (QuestionUUID, DescriptionUUID, Model);
+CREATE INDEX IF NOT EXISTS descriptions_suspect ON descriptions (SuspectUUID, Model);
+CREATE INDEX IF NOT EXISTS games_player ON games (player_uuid, timestamp);
+CREATE INDEX IF NOT EXISTS describe_jobs_model ON describe_jobs (Model, Status);
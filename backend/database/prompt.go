@@ -0,0 +1,191 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/google/uuid"
+)
+
+// Names of the prompts used by the game.
+const (
+	PromptDescribe         string = "describe"          // describe the suspect's portrait, no variables
+	PromptAnswerReflection string = "answer_reflection" // reflect on the question, {{.Question}} and {{.Description}}
+	PromptAnswerDecision   string = "answer_decision"   // decide YES or NO, no variables
+	// Reflect on the question in vision witness mode, portrait is attached to the message, {{.Question}}
+	PromptAnswerVisionReflection string = "answer_vision_reflection"
+)
+
+// Prompt templates used when the database does not have any version of the prompt yet.
+var defaultPrompts = map[string]string{
+	PromptDescribe:               describePrompt,
+	PromptAnswerReflection:       answerReflection,
+	PromptAnswerDecision:         answerBoolean,
+	PromptAnswerVisionReflection: answerVisionReflection,
+}
+
+// Versioned template of the prompt. There can be many versions of one named prompt,
+// but only one is Active and used for new descriptions and answers.
+// Template uses text/template syntax.
+type Prompt struct {
+	UUID      string `json:"UUID"`
+	Name      string `json:"Name"`
+	Version   int    `json:"Version"`
+	Template  string `json:"Template"`
+	Active    bool   `json:"Active"`
+	Timestamp string `json:"Timestamp"`
+}
+
+// Variables available in PromptAnswerReflection template, PromptAnswerVisionReflection has only the Question.
+type reflectionPromptData struct {
+	Question    string
+	Description string
+}
+
+// Render the template with the data.
+func (p Prompt) Render(data any) (string, error) {
+	tmpl, err := template.New(p.Name).Option("missingkey=error").Parse(p.Template)
+	if err != nil {
+		return "", fmt.Errorf("could not parse prompt %s v%d: %w", p.Name, p.Version, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("could not render prompt %s v%d: %w", p.Name, p.Version, err)
+	}
+	return b.String(), nil
+}
+
+// Save default prompts as version 1 if there is no version of them in the database.
+func ensureDefaultPrompts() error {
+	for name, text := range defaultPrompts {
+		var exists bool
+		err := database.QueryRow("SELECT EXISTS(SELECT 1 FROM prompts WHERE Name = $1)", name).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		prompt, err := SavePrompt(name, text)
+		if err != nil {
+			return err
+		}
+		if err := ActivatePrompt(name, prompt.Version); err != nil {
+			return err
+		}
+		log.Printf("%s Saved default prompt %s", emoDB, name)
+	}
+	return nil
+}
+
+// Save new version of the named prompt. New version is not active, use ActivatePrompt() to start using it.
+func SavePrompt(name, text string) (Prompt, error) {
+	if _, known := defaultPrompts[name]; !known {
+		return Prompt{}, fmt.Errorf("unknown prompt name %s", name)
+	}
+	prompt := Prompt{
+		UUID:      uuid.New().String(),
+		Name:      name,
+		Template:  text,
+		Timestamp: TimestampNow(),
+	}
+	if _, err := prompt.Render(testPromptData(name)); err != nil {
+		return prompt, err
+	}
+
+	err := database.QueryRow("SELECT COALESCE(MAX(Version), 0) + 1 FROM prompts WHERE Name = $1", name).Scan(&prompt.Version)
+	if err != nil {
+		return prompt, fmt.Errorf("could not get next version of prompt %s: %w", name, err)
+	}
+
+	query := "INSERT INTO prompts (UUID, Name, Version, Template, Active, Timestamp) VALUES (?, ?, ?, ?, ?, ?)"
+	_, err = database.Exec(query, prompt.UUID, prompt.Name, prompt.Version, prompt.Template, prompt.Active, prompt.Timestamp)
+	if err != nil {
+		return prompt, fmt.Errorf("could not save prompt %s v%d: %w", name, prompt.Version, err)
+	}
+	return prompt, nil
+}
+
+// Make the version of the named prompt the active one, other versions are deactivated.
+func ActivatePrompt(name string, version int) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE prompts SET Active = 1 WHERE Name = $1 AND Version = $2", name, version)
+	if err != nil {
+		return fmt.Errorf("could not activate prompt %s v%d: %w", name, version, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("prompt %s v%d does not exist", name, version)
+	}
+	_, err = tx.Exec("UPDATE prompts SET Active = 0 WHERE Name = $1 AND Version != $2", name, version)
+	if err != nil {
+		return fmt.Errorf("could not deactivate other versions of prompt %s: %w", name, err)
+	}
+	return tx.Commit()
+}
+
+// Get the active version of the named prompt.
+func GetActivePrompt(name string) (Prompt, error) {
+	var prompt Prompt
+	query := "SELECT UUID, Name, Version, Template, Active, Timestamp FROM prompts WHERE Name = $1 AND Active = 1 LIMIT 1"
+	err := database.QueryRow(query, name).Scan(&prompt.UUID, &prompt.Name, &prompt.Version, &prompt.Template, &prompt.Active, &prompt.Timestamp)
+	if err == sql.ErrNoRows {
+		return prompt, fmt.Errorf("no active version of prompt %s", name)
+	}
+	if err != nil {
+		return prompt, fmt.Errorf("could not get active prompt %s: %w", name, err)
+	}
+	return prompt, nil
+}
+
+// Get all versions of all prompts, or only of the named one if name is not empty.
+func GetPrompts(name string) ([]Prompt, error) {
+	var prompts []Prompt
+	query := "SELECT UUID, Name, Version, Template, Active, Timestamp FROM prompts WHERE $1 = '' OR Name = $1 ORDER BY Name, Version"
+	rows, err := database.Query(query, name)
+	if err != nil {
+		return prompts, fmt.Errorf("could not get prompts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var prompt Prompt
+		err := rows.Scan(&prompt.UUID, &prompt.Name, &prompt.Version, &prompt.Template, &prompt.Active, &prompt.Timestamp)
+		if err != nil {
+			return prompts, fmt.Errorf("could not scan prompt: %w", err)
+		}
+		prompts = append(prompts, prompt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return prompts, fmt.Errorf("prompt rows iteration error: %w", err)
+	}
+	return prompts, nil
+}
+
+// Data to check that the template of the named prompt renders before it is saved.
+func testPromptData(name string) any {
+	if name == PromptAnswerReflection || name == PromptAnswerVisionReflection {
+		return reflectionPromptData{Question: "question", Description: "description"}
+	}
+	return nil
+}
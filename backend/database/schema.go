@@ -0,0 +1,109 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Migrations which are Go functions, see goMigrations in migrations.go. SQL migrations are in migrations/.
+
+// Columns the code queried already before the versioned migrations, although the shipped default.db
+// did not have them. Deployed databases may have them added by hand, so only the missing ones are added.
+var legacyColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"games", "player_uuid", "TEXT"},
+	{"games", "model", "TEXT"},
+	{"services", "API_style", "TEXT"},
+	{"models", "price", "REAL DEFAULT 0"},
+	{"models", "weight", "INT DEFAULT 0"},
+	{"models", "Visual", "INTEGER NOT NULL DEFAULT 0"},
+	{"models", "Allowed", "INTEGER NOT NULL DEFAULT 0"},
+	{"models", "Historical", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+func migrateLegacyColumns(tx *sql.Tx) error {
+	for _, c := range legacyColumns {
+		if err := ensureColumn(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Number of sus<N>_uuid columns investigations table had before suspects moved to investigation_suspects.
+const legacySuspectColumns = 15
+
+// Move suspects of investigations from the sus1_uuid...sus15_uuid columns into investigation_suspects table,
+// so the board can have any size.
+func migrateInvestigationSuspects(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE investigation_suspects (
+		investigation_uuid TEXT NOT NULL,
+		suspect_uuid TEXT NOT NULL,
+		position INTEGER NOT NULL, -- 1 is the first on the board
+		PRIMARY KEY (investigation_uuid, position)
+	)`)
+	if err != nil {
+		return fmt.Errorf("could not create investigation_suspects table: %w", err)
+	}
+	if _, err := tx.Exec("ALTER TABLE games ADD COLUMN board_size INTEGER NOT NULL DEFAULT 15"); err != nil {
+		return fmt.Errorf("could not add column games.board_size: %w", err)
+	}
+
+	log.Printf("%s Moving suspects of investigations into investigation_suspects", emoDB)
+	for n := 1; n <= legacySuspectColumns; n++ {
+		query := fmt.Sprintf(`INSERT INTO investigation_suspects (investigation_uuid, suspect_uuid, position)
+			SELECT uuid, sus%[1]d_uuid, %[1]d FROM investigations WHERE sus%[1]d_uuid IS NOT NULL AND sus%[1]d_uuid != ''`, n)
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("could not move suspects of investigations: %w", err)
+		}
+	}
+	for n := 1; n <= legacySuspectColumns; n++ {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE investigations DROP COLUMN sus%d_uuid", n)); err != nil {
+			return fmt.Errorf("could not drop column investigations.sus%d_uuid: %w", n, err)
+		}
+	}
+	return nil
+}
+
+// Add the column to the table, unless it is already there.
+func ensureColumn(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	log.Printf("%s Adding column %s.%s", emoDB, table, column)
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("could not add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ? COLLATE NOCASE)"
+	err := tx.QueryRow(query, table, column).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("could not check column %s.%s: %w", table, column, err)
+	}
+	return exists, nil
+}
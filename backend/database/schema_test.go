@@ -0,0 +1,201 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Open new empty database in the temporary directory of the test as the global database.
+func openTestDB(t *testing.T, name string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	database = db
+	databasePath = path
+	databaseCreated = true
+}
+
+// Columns and indexes of all tables of the opened database, one line each, sorted.
+func describeSchema(t *testing.T) []string {
+	t.Helper()
+	rows, err := database.Query(`SELECT m.name, c.name, upper(c.type), c."notnull", COALESCE(c.dflt_value, ''), c.pk
+		FROM sqlite_master m JOIN pragma_table_info(m.name) c
+		WHERE m.type = 'table' AND m.name NOT IN ('schema_version', 'sqlite_sequence')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var table, column, kind, def string
+		var notNull, pk int
+		if err := rows.Scan(&table, &column, &kind, &notNull, &def, &pk); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, fmt.Sprintf("%s.%s %s notnull=%d default=%s pk=%d", table, column, kind, notNull, def, pk))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexes, err := database.Query("SELECT tbl_name, name FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexes.Close()
+	for indexes.Next() {
+		var table, name string
+		if err := indexes.Scan(&table, &name); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, fmt.Sprintf("%s index %s", table, name))
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+// Database copied from the old default.db must end up with the same schema as new database.
+func TestLegacyDBMigratesToNewSchema(t *testing.T) {
+	openTestDB(t, "new.db")
+	if _, err := MigrateDB(); err != nil {
+		t.Fatal(err)
+	}
+	created := describeSchema(t)
+
+	openTestDB(t, "legacy.db")
+	legacy, err := os.ReadFile("testdata/legacy_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Exec(string(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateDB(); err != nil {
+		t.Fatal(err)
+	}
+	migrated := describeSchema(t)
+
+	for _, line := range created {
+		if !slices.Contains(migrated, line) {
+			t.Errorf("only in the new database: %s", line)
+		}
+	}
+	for _, line := range migrated {
+		if !slices.Contains(created, line) {
+			t.Errorf("only in the migrated legacy database: %s", line)
+		}
+	}
+}
+
+func TestBootstrapDBCanPlay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artsus.db")
+	result, err := BootstrapDB(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	want := BootstrapResult{
+		Questions:    len(defaultQuestions),
+		Services:     len(defaultServices),
+		Models:       len(defaultModels),
+		Suspects:     57,
+		Descriptions: 82,
+	}
+	if result != want {
+		t.Errorf("BootstrapDB() = %+v, want %+v", result, want)
+	}
+
+	models, err := GetModels(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) == 0 {
+		t.Fatal("no model is allowed to play")
+	}
+	for _, model := range models {
+		var missing int
+		err := database.QueryRow(`SELECT COUNT(*) FROM suspects s WHERE NOT EXISTS
+			(SELECT 1 FROM descriptions d WHERE d.SuspectUUID = s.uuid AND d.Model = $1 AND d.Rejected = 0)`, model.Name).Scan(&missing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if missing > 0 {
+			t.Errorf("allowed model %s has no description of %d suspects", model.Name, missing)
+		}
+	}
+
+	var tokens int
+	if err := database.QueryRow("SELECT COUNT(*) FROM services WHERE Token != ''").Scan(&tokens); err != nil {
+		t.Fatal(err)
+	}
+	if tokens > 0 {
+		t.Errorf("%d services were seeded with a token", tokens)
+	}
+
+	if _, err := BootstrapDB(path, ""); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("BootstrapDB() of existing database: %v, want already exists error", err)
+	}
+}
+
+// default.db is rebuilt from the seed, SyncContent() of bootstrapped database must find nothing to add.
+func TestDefaultDBMatchesSeed(t *testing.T) {
+	if _, err := BootstrapDB(filepath.Join(t.TempDir(), "artsus.db"), ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	report, err := SyncContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range report.Tables {
+		count, err := countRows(table.Table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table.Added != 0 || table.Skipped != count {
+			t.Errorf("%s: default.db differs from the seed, added %d and skipped %d of %d rows, rebuild it by dev db bootstrap",
+				table.Table, table.Added, table.Skipped, count)
+		}
+	}
+}
+
+// Content of default.db is synced only by dev db sync, reopening the database must not add back deleted rows.
+func TestReopenKeepsDeletedModel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artsus.db")
+	if _, err := BootstrapDB(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	name := defaultModels[0].Name
+	if _, err := database.Exec("DELETE FROM models WHERE Name = $1", name); err != nil {
+		t.Fatal(err)
+	}
+	database.Close()
+
+	if err := EnsureDBAvailable(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetModel(name); err == nil {
+		t.Errorf("deleted model %s is back after reopening the database", name)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Content sync brings new suspects, questions, models and descriptions shipped in the embedded default.db
+// into existing database. It only inserts rows which are missing, it never updates or deletes rows,
+// and it never touches games, investigations, rounds or scores. It runs only by dev db sync,
+// so the rows deleted by the operator are not added back on the next start.
+// default.db is bootstrapped from the seed, see bootstrap.go.
+
+// Table synced from default.db. Row is missing when no row of the database matches it by any of the keys,
+// each key being a list of columns which all must be equal.
+type contentTable struct {
+	name string
+	keys [][]string
+}
+
+// In this order, so suspects are in place before their descriptions.
+var contentTables = []contentTable{
+	{name: "suspects", keys: [][]string{{"uuid"}, {"image"}}},
+	{name: "questions", keys: [][]string{{"UUID"}, {"English"}}},
+	{name: "models", keys: [][]string{{"Name"}}},
+	{name: "descriptions", keys: [][]string{{"UUID"}, {"SuspectUUID", "Model", "Description"}}},
+}
+
+// What SyncContent() did with one table.
+type SyncTableReport struct {
+	Table   string
+	Added   int
+	Skipped int // rows of default.db which the database already has
+}
+
+// What SyncContent() changed, tables are in the order of syncing.
+type SyncReport struct {
+	Tables   []SyncTableReport
+	Models   []string          // names of the added Models
+	Rejected map[string]string // UUID of the added Description -> why it failed ValidateDescription(), it is stored rejected
+}
+
+// Number of rows added to all tables.
+func (r SyncReport) Total() int {
+	total := 0
+	for _, table := range r.Tables {
+		total += table.Added
+	}
+	return total
+}
+
+// Insert the suspects, questions, models and descriptions from the embedded default.db
+// which are missing in the opened database. All or nothing is inserted.
+// Added descriptions are validated like the generated ones, those which fail are stored rejected.
+func SyncContent() (SyncReport, error) {
+	report := SyncReport{Rejected: make(map[string]string)}
+	content, err := defaultDB.ReadFile("default.db")
+	if err != nil {
+		return report, err
+	}
+	file, err := os.CreateTemp("", "artsus-default-*.db") // SQLite attaches only files
+	if err != nil {
+		return report, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return report, fmt.Errorf("could not extract default.db: %w", err)
+	}
+
+	// Attached database is visible only on the connection which attached it.
+	ctx := context.Background()
+	conn, err := database.Conn(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS content", file.Name()); err != nil {
+		return report, fmt.Errorf("could not attach default.db: %w", err)
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE content")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range contentTables {
+		// Rows added by the sync get higher rowid than all rows the table had before.
+		var lastRowID int64
+		if err := tx.QueryRow(fmt.Sprintf("SELECT COALESCE(MAX(rowid), 0) FROM main.%s", table.name)).Scan(&lastRowID); err != nil {
+			return report, fmt.Errorf("could not check %s: %w", table.name, err)
+		}
+		tableReport, err := syncContentTable(tx, table)
+		if err != nil {
+			return report, err
+		}
+		report.Tables = append(report.Tables, tableReport)
+
+		switch table.name {
+		case "models":
+			report.Models, err = syncedModels(tx, lastRowID)
+		case "descriptions":
+			err = rejectSyncedDescriptions(tx, lastRowID, report.Rejected)
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return SyncReport{}, err
+	}
+	if len(report.Rejected) > 0 {
+		log.Printf("%s %d synced descriptions failed the validation, stored as rejected", emoDB, len(report.Rejected))
+	}
+	return report, nil
+}
+
+// Insert the missing rows of the table. Only columns present in both databases
+// are copied, the others get their defaults, so default.db may be older or newer than the schema.
+func syncContentTable(tx *sql.Tx, table contentTable) (SyncTableReport, error) {
+	report := SyncTableReport{Table: table.name}
+	columns, sources, err := commonColumns(tx, table)
+	if err != nil {
+		return report, err
+	}
+	if len(columns) == 0 {
+		return report, nil
+	}
+
+	var matches []string
+	for _, key := range table.keys {
+		var conditions []string
+		for _, column := range key {
+			conditions = append(conditions, fmt.Sprintf(`t."%[1]s" = c."%[1]s"`, column))
+		}
+		matches = append(matches, "("+strings.Join(conditions, " AND ")+")")
+	}
+	quoted := make([]string, len(columns))
+	selected := make([]string, len(sources))
+	for i := range columns {
+		quoted[i] = `"` + columns[i] + `"`
+		selected[i] = `c."` + sources[i] + `"`
+	}
+
+	query := fmt.Sprintf(`INSERT INTO main.%[1]s (%[2]s) SELECT %[3]s FROM content.%[1]s c
+		WHERE NOT EXISTS (SELECT 1 FROM main.%[1]s t WHERE %[4]s)`,
+		table.name, strings.Join(quoted, ", "), strings.Join(selected, ", "), strings.Join(matches, " OR "))
+	result, err := tx.Exec(query)
+	if err != nil {
+		return report, fmt.Errorf("could not sync %s: %w", table.name, err)
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return report, err
+	}
+	report.Added = int(added)
+
+	var total int
+	if err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM content.%s", table.name)).Scan(&total); err != nil {
+		return report, fmt.Errorf("could not count %s of default.db: %w", table.name, err)
+	}
+	report.Skipped = total - report.Added
+	if added > 0 {
+		log.Printf("%s Synced %d %s from default.db", emoDB, added, table.name)
+	}
+	return report, nil
+}
+
+// Columns of the table in the database which can be filled from the attached default.db, in the order
+// of the database, and the columns of default.db they are filled from.
+func commonColumns(tx *sql.Tx, table contentTable) ([]string, []string, error) {
+	query := `SELECT t.name, c.name FROM pragma_table_info($1, 'main') t
+		JOIN pragma_table_info($1, 'content') c ON c.name = t.name COLLATE NOCASE ORDER BY t.cid`
+	rows, err := tx.Query(query, table.name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get columns of %s: %w", table.name, err)
+	}
+	defer rows.Close()
+
+	var columns, sources []string
+	for rows.Next() {
+		var column, source string
+		if err := rows.Scan(&column, &source); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, column)
+		sources = append(sources, source)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return columns, sources, nil
+}
+
+// Names of the Models added after lastRowID.
+func syncedModels(tx *sql.Tx, lastRowID int64) ([]string, error) {
+	rows, err := tx.Query("SELECT Name FROM main.models WHERE rowid > $1 ORDER BY Name", lastRowID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get synced models: %w", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Validate the Descriptions added after lastRowID, mark those which fail as rejected.
+func rejectSyncedDescriptions(tx *sql.Tx, lastRowID int64, rejected map[string]string) error {
+	rows, err := tx.Query("SELECT UUID, Description FROM main.descriptions WHERE rowid > $1 AND Rejected = 0", lastRowID)
+	if err != nil {
+		return fmt.Errorf("could not get synced descriptions: %w", err)
+	}
+	failed := make(map[string]string)
+	for rows.Next() {
+		var uuid, text string
+		if err := rows.Scan(&uuid, &text); err != nil {
+			rows.Close()
+			return err
+		}
+		var rejection *RejectionError
+		if errors.As(ValidateDescription(text), &rejection) {
+			failed[uuid] = rejection.Reason
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for uuid, reason := range failed {
+		if _, err := tx.Exec("UPDATE main.descriptions SET Rejected = 1, RejectionReason = $1 WHERE UUID = $2", reason, uuid); err != nil {
+			return fmt.Errorf("could not reject synced description %s: %w", uuid, err)
+		}
+		rejected[uuid] = reason
+	}
+	return nil
+}
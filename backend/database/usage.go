@@ -0,0 +1,129 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/agajdosi/artificial_suspects/backend/llm"
+	"github.com/google/uuid"
+)
+
+// Kinds of the calls recorded in token_usage table.
+const (
+	UsageDescribe string = "describe"
+	UsageAnswer   string = "answer"
+)
+
+// Tokens consumed by the call and their cost in USD, computed from the prices of the Model, see Model.prices().
+type Usage struct {
+	PromptTokens     int     `json:"PromptTokens"`
+	CompletionTokens int     `json:"CompletionTokens"`
+	Cost             float64 `json:"Cost"`
+}
+
+// Compute the Usage with cost of the call made by the model. Prices are per 1M tokens.
+// Models without price (local ones, or not filled in yet) cost 0.
+func newUsage(usage llm.Usage, model Model) Usage {
+	inputPrice, outputPrice := model.prices()
+	return Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             (float64(usage.PromptTokens)*inputPrice + float64(usage.CompletionTokens)*outputPrice) / 1_000_000,
+	}
+}
+
+// Prices of prompt and completion tokens. Split InputPrice and OutputPrice win,
+// Models which have only the single price from older databases use it for both.
+func (m Model) prices() (input, output float64) {
+	if m.InputPrice == 0 && m.OutputPrice == 0 {
+		return m.Price, m.Price
+	}
+	return m.InputPrice, m.OutputPrice
+}
+
+// Save the Usage of the call into token_usage table. Reference is UUID of the Description or Answer
+// the call produced. Errors are only logged, accounting must not break the game.
+func recordUsage(kind, serviceName, modelName, referenceUUID string, usage Usage) {
+	now := time.Now()
+	query := `INSERT INTO token_usage
+		(UUID, Kind, Service, Model, ReferenceUUID, PromptTokens, CompletionTokens, Cost, Day, Timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := database.Exec(query, uuid.New().String(), kind, serviceName, modelName, referenceUUID,
+		usage.PromptTokens, usage.CompletionTokens, usage.Cost, now.Format(time.DateOnly), now.Format(TimeFormat),
+	)
+	if err != nil {
+		log.Printf("Could not record usage of %s by model %s: %v\n", kind, modelName, err)
+	}
+}
+
+// Aggregated Usage of the calls sharing the Key - name of the Model, UUID of the Game or the day.
+type UsageSummary struct {
+	Key              string  `json:"Key"`
+	Calls            int     `json:"Calls"`
+	PromptTokens     int     `json:"PromptTokens"`
+	CompletionTokens int     `json:"CompletionTokens"`
+	Cost             float64 `json:"Cost"`
+}
+
+// Expressions to group token_usage by. Games are found through the answers, descriptions
+// and answers not saved to any Round have empty Key.
+var usageGroups = map[string]string{
+	"model": "token_usage.Model",
+	"day":   "token_usage.Day",
+	"game":  "COALESCE(investigations.game_uuid, '')",
+	"kind":  "token_usage.Kind",
+}
+
+// Get the Usage aggregated by groupBy: model, game, day or kind. Ordered by cost, most expensive first.
+func GetUsageSummary(groupBy string) ([]UsageSummary, error) {
+	var summaries []UsageSummary
+	group, ok := usageGroups[groupBy]
+	if !ok {
+		return summaries, fmt.Errorf("cannot group usage by %q, use model, game, day or kind", groupBy)
+	}
+
+	query := fmt.Sprintf(`
+	SELECT
+		%s AS key,
+		COUNT(*),
+		SUM(token_usage.PromptTokens),
+		SUM(token_usage.CompletionTokens),
+		SUM(token_usage.Cost)
+	FROM token_usage
+	LEFT JOIN answers ON token_usage.ReferenceUUID = answers.UUID
+	LEFT JOIN rounds ON answers.RoundUUID = rounds.uuid
+	LEFT JOIN investigations ON rounds.investigation_uuid = investigations.uuid
+	GROUP BY key
+	ORDER BY SUM(token_usage.Cost) DESC`, group)
+	rows, err := database.Query(query)
+	if err != nil {
+		return summaries, fmt.Errorf("failed to get usage: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s UsageSummary
+		err := rows.Scan(&s.Key, &s.Calls, &s.PromptTokens, &s.CompletionTokens, &s.Cost)
+		if err != nil {
+			return summaries, fmt.Errorf("failed to scan usage row: %w", err)
+		}
+		summaries = append(summaries, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return summaries, fmt.Errorf("usage rows iteration error: %w", err)
+	}
+	return summaries, nil
+}
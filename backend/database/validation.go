@@ -0,0 +1,203 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// Tunables of the Description validation.
+var (
+	DescriptionMinWords    = 150 // describePrompt asks for 500-800 words, much shorter text is a refusal or cut off
+	DescriptionMaxAttempts = 3   // How many times GenerateDescription() asks the model before giving up
+	// Minimal share of common English words in the text. Usual English prose has around 40%.
+	DescriptionMinEnglishShare = 0.2
+)
+
+// Reasons why the Description was rejected.
+const (
+	RejectedRefusal  string = "refusal"
+	RejectedTooShort string = "too_short"
+	RejectedLanguage string = "language"
+)
+
+var ErrDescriptionRejected = errors.New("description rejected")
+
+// Description did not pass the validation. Wraps ErrDescriptionRejected.
+type RejectionError struct {
+	Reason string // RejectedRefusal, RejectedTooShort or RejectedLanguage
+	Detail string
+}
+
+func (e *RejectionError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", ErrDescriptionRejected, e.Reason, e.Detail)
+}
+
+func (e *RejectionError) Unwrap() error {
+	return ErrDescriptionRejected
+}
+
+// Phrases models open the refusal with. Matched only as the first words of the text.
+var refusalOpenings = []string{
+	"i'm sorry",
+	"i am sorry",
+	"sorry",
+	"i apologize",
+	"as an ai",
+	"unfortunately i can't",
+	"unfortunately i cannot",
+}
+
+// Phrases of the refusal itself. Matched as whole words in the first sentence only,
+// the description may legitimately say "I cannot tell his exact age" later on.
+var refusalPhrases = []string{
+	"can't help with",
+	"cannot help with",
+	"can't assist",
+	"cannot assist",
+	"unable to assist",
+	"unable to help",
+	"can't identify",
+	"cannot identify",
+	"unable to identify",
+	"not able to identify",
+	"can't provide a description",
+	"cannot provide a description",
+	"unable to provide a description",
+}
+
+const refusalWindow = 300 // the first sentence is cut to this many characters
+
+// Common English words, their share in the text tells whether the text is in English.
+var englishWords = map[string]bool{
+	"the": true, "a": true, "an": true, "and": true, "or": true, "but": true, "of": true, "to": true,
+	"in": true, "on": true, "at": true, "with": true, "for": true, "from": true, "by": true, "as": true,
+	"is": true, "are": true, "was": true, "were": true, "be": true, "been": true, "has": true, "have": true,
+	"this": true, "that": true, "these": true, "their": true, "they": true, "them": true, "his": true, "her": true,
+	"he": true, "she": true, "it": true, "its": true, "who": true, "which": true, "may": true, "might": true,
+	"could": true, "would": true, "not": true, "into": true, "there": true, "than": true, "more": true, "such": true,
+}
+
+// Check that the text is usable as the Description of the Suspect: it is not a refusal,
+// it is long enough and it is written in English. Returns *RejectionError if it is not.
+func ValidateDescription(text string) error {
+	if phrase := findRefusal(text); phrase != "" {
+		return &RejectionError{Reason: RejectedRefusal, Detail: fmt.Sprintf("contains %q", phrase)}
+	}
+
+	words := splitWords(text)
+	if len(words) < DescriptionMinWords {
+		return &RejectionError{Reason: RejectedTooShort, Detail: fmt.Sprintf("%d words, at least %d needed", len(words), DescriptionMinWords)}
+	}
+
+	english := 0
+	for _, word := range words {
+		if englishWords[word] {
+			english++
+		}
+	}
+	share := float64(english) / float64(len(words))
+	if share < DescriptionMinEnglishShare {
+		return &RejectionError{Reason: RejectedLanguage, Detail: fmt.Sprintf("%.0f%% common English words", share*100)}
+	}
+	return nil
+}
+
+// Return the refusal phrase the text opens with or has in its first sentence, empty if there is none.
+func findRefusal(text string) string {
+	firstSentence := strings.TrimSpace(text)
+	if end := strings.IndexAny(firstSentence, ".!?\n"); end >= 0 {
+		firstSentence = firstSentence[:end]
+	}
+	if len(firstSentence) > refusalWindow {
+		firstSentence = firstSentence[:refusalWindow]
+	}
+	words := splitWords(firstSentence)
+
+	for _, phrase := range refusalOpenings {
+		if hasWordsAt(words, splitWords(phrase), 0) {
+			return phrase
+		}
+	}
+	for _, phrase := range refusalPhrases {
+		phraseWords := splitWords(phrase)
+		for i := range words {
+			if hasWordsAt(words, phraseWords, i) {
+				return phrase
+			}
+		}
+	}
+	return ""
+}
+
+// Lowercase words of the text, apostrophes are kept so "can't" stays one word.
+func splitWords(text string) []string {
+	text = strings.ReplaceAll(strings.ToLower(text), "’", "'")
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+}
+
+// Whether words continue with the phrase at position i.
+func hasWordsAt(words, phrase []string, i int) bool {
+	if i+len(phrase) > len(words) {
+		return false
+	}
+	for j, word := range phrase {
+		if words[i+j] != word {
+			return false
+		}
+	}
+	return true
+}
+
+// Run ValidateDescription() on all stored Descriptions which are not rejected yet
+// and mark those which fail as rejected. Returns number of checked and newly rejected Descriptions.
+func ValidateStoredDescriptions() (int, int, error) {
+	rows, err := database.Query("SELECT UUID, Description FROM descriptions WHERE Rejected = 0")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get descriptions: %w", err)
+	}
+	type stored struct{ uuid, text string }
+	var descriptions []stored
+	for rows.Next() {
+		var d stored
+		if err := rows.Scan(&d.uuid, &d.text); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan description row: %w", err)
+		}
+		descriptions = append(descriptions, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("description rows iteration error: %w", err)
+	}
+
+	rejected := 0
+	for _, d := range descriptions {
+		var rejection *RejectionError
+		if !errors.As(ValidateDescription(d.text), &rejection) {
+			continue
+		}
+		_, err := database.Exec("UPDATE descriptions SET Rejected = 1, RejectionReason = $1 WHERE UUID = $2", rejection.Reason, d.uuid)
+		if err != nil {
+			return len(descriptions), rejected, fmt.Errorf("failed to reject description %s: %w", d.uuid, err)
+		}
+		log.Printf("Rejected description %s: %v\n", d.uuid, rejection)
+		rejected++
+	}
+	return len(descriptions), rejected, nil
+}
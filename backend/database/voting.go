@@ -0,0 +1,145 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/agajdosi/artificial_suspects/backend/llm"
+)
+
+// Distribution of the Decisions when the witness was asked the same question multiple times.
+// Shows how unstable the model is, e.g. "the model said YES 7/10 times".
+type Votes struct {
+	Yes         int `json:"Yes"`
+	No          int `json:"No"`
+	Unparseable int `json:"Unparseable"`
+}
+
+func (v Votes) Samples() int {
+	return v.Yes + v.No + v.Unparseable
+}
+
+func (v *Votes) add(d Decision) {
+	switch d {
+	case DecisionYes:
+		v.Yes++
+	case DecisionNo:
+		v.No++
+	default:
+		v.Unparseable++
+	}
+}
+
+// Majority of the valid Decisions. On tie the tieBreak Decision wins.
+// Unparseable only if there was no valid Decision at all.
+func (v Votes) Majority(tieBreak Decision) Decision {
+	switch {
+	case v.Yes > v.No:
+		return DecisionYes
+	case v.No > v.Yes:
+		return DecisionNo
+	case v.Yes == 0:
+		return DecisionUnparseable
+	}
+	return tieBreak
+}
+
+var (
+	answerSamplesMu sync.RWMutex
+	answerSamples   = 1
+)
+
+// Set how many times GenerateAnswer() asks the model, the majority Decision is then returned. Default is 1.
+func SetAnswerSamples(n int) error {
+	if n < 1 {
+		return fmt.Errorf("number of answer samples must be at least 1, got %d", n)
+	}
+	answerSamplesMu.Lock()
+	defer answerSamplesMu.Unlock()
+	answerSamples = n
+	return nil
+}
+
+func getAnswerSamples() int {
+	answerSamplesMu.RLock()
+	defer answerSamplesMu.RUnlock()
+	return answerSamples
+}
+
+// Ask the Provider n times in parallel and vote.
+// Returns the sample which agrees with the majority (the first one on tie), the Votes and usage of all samples,
+// including the failed ones which got billed before they failed.
+// If the request streams the reflection, only the first sample is streamed and it is the one returned,
+// so the client sees the same reflection which gets stored. Its Decision may differ from the majority.
+// With fixed seed, sample i is sent with seed+i, so the samples differ but the whole vote stays reproducible.
+// Failed samples are left out of the vote, error is returned only if all of them failed.
+func sampleAnswers(ctx context.Context, provider llm.Provider, service llm.Service, req llm.AnswerRequest, n int) (llm.Answer, Votes, llm.Usage, error) {
+	type sample struct {
+		answer   llm.Answer
+		decision Decision
+		err      error
+	}
+	samples := make([]sample, n)
+	var wg sync.WaitGroup
+	for i := range samples {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := req
+			if i > 0 { // only the first sample is streamed
+				req.OnReflectionToken = nil
+			}
+			if req.Sampling.Seed != nil {
+				seed := *req.Sampling.Seed + i
+				req.Sampling.Seed = &seed
+			}
+			answer, err := provider.Answer(ctx, service, req)
+			samples[i] = sample{answer: answer, decision: ParseDecision(answer.Decision), err: err}
+		}()
+	}
+	wg.Wait()
+
+	var votes Votes
+	var usage llm.Usage
+	var lastErr error
+	tieBreak := DecisionUnparseable
+	for _, s := range samples {
+		usage = usage.Add(s.answer.Usage)
+		if s.err != nil {
+			log.Printf("Answer sample of model %s failed: %v\n", req.Model, s.err)
+			lastErr = s.err
+			continue
+		}
+		votes.add(s.decision)
+		if tieBreak == DecisionUnparseable && s.decision.Valid() {
+			tieBreak = s.decision
+		}
+	}
+	if votes.Samples() == 0 {
+		return llm.Answer{}, votes, usage, lastErr
+	}
+
+	if req.OnReflectionToken != nil && samples[0].err == nil {
+		return samples[0].answer, votes, usage, nil
+	}
+	majority := votes.Majority(tieBreak)
+	for _, s := range samples {
+		if s.err == nil && s.decision == majority {
+			return s.answer, votes, usage, nil
+		}
+	}
+	return llm.Answer{}, votes, usage, lastErr
+}
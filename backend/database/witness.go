@@ -0,0 +1,48 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// How the witness of the Game learns what the criminal looks like. It is chosen for each Game
+// and stored with it and with each Answer, so the bias of the description step can be told apart
+// from the bias of the vision step.
+type WitnessMode string
+
+const (
+	WitnessDescription WitnessMode = "description" // Witness reads a Description of the portrait generated in advance
+	WitnessVision      WitnessMode = "vision"      // Visual model looks at the portrait itself, no Description is used
+)
+
+var ErrModelNotVisual = errors.New("model is not visual")
+
+// Parse the mode from the text, empty text is WitnessDescription.
+func ParseWitnessMode(text string) (WitnessMode, error) {
+	switch WitnessMode(text) {
+	case "", WitnessDescription:
+		return WitnessDescription, nil
+	case WitnessVision:
+		return WitnessVision, nil
+	}
+	return "", fmt.Errorf("unknown witness mode %q, use %s or %s", text, WitnessDescription, WitnessVision)
+}
+
+// Check that the Model can be the witness in the mode: vision mode needs a visual Model.
+func checkWitnessModel(model Model, mode WitnessMode) error {
+	if mode == WitnessVision && !model.Visual {
+		return fmt.Errorf("%w: %s cannot be the witness in %s mode", ErrModelNotVisual, model.Name, mode)
+	}
+	return nil
+}
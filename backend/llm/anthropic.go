@@ -0,0 +1,349 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	apiStyleAnthropic   string = "anthropic"
+	anthropicBaseURL    string = "https://api.anthropic.com"
+	anthropicAPIVersion string = "2023-06-01"
+	anthropicMaxTokens  int    = 2048 // Messages API requires max_tokens, descriptions are 500-800 words
+)
+
+func init() {
+	Register(apiStyleAnthropic, anthropicProvider{})
+}
+
+// Provider for native Anthropic Messages API.
+// If Service defines non-empty URL, it is used instead of the default Anthropic base URL.
+type anthropicProvider struct{}
+
+// Single message of the Anthropic Messages API conversation.
+// Content is a list of blocks, so text and images can be mixed in one message.
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type   string                `json:"type"`             // text or image
+	Text   string                `json:"text,omitempty"`   // set when Type is text
+	Source *anthropicImageSource `json:"source,omitempty"` // set when Type is image
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"` // always base64 for us
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	Messages    []anthropicMessage `json:"messages"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	TopP        *float64           `json:"top_p,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+// Request with the sampling parameters set. Messages API has no seed, so Sampling.Seed is ignored.
+func newAnthropicRequest(model string, sampling Sampling, messages ...anthropicMessage) anthropicRequest {
+	request := anthropicRequest{
+		Model:       model,
+		MaxTokens:   anthropicMaxTokens,
+		Messages:    messages,
+		Temperature: sampling.Temperature,
+		TopP:        sampling.TopP,
+	}
+	if sampling.MaxTokens != nil {
+		request.MaxTokens = *sampling.MaxTokens
+	}
+	return request
+}
+
+type anthropicResponse struct {
+	ID         string                  `json:"id"`
+	Model      string                  `json:"model"`
+	Content    []anthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      anthropicUsage          `json:"usage"`
+	Error      *anthropicError         `json:"error,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// HTTP status of the error types, for errors sent as event of the stream after 200 OK.
+var anthropicErrorStatus = map[string]int{
+	"invalid_request_error": http.StatusBadRequest,
+	"authentication_error":  http.StatusUnauthorized,
+	"permission_error":      http.StatusForbidden,
+	"not_found_error":       http.StatusNotFound,
+	"request_too_large":     http.StatusRequestEntityTooLarge,
+	"rate_limit_error":      http.StatusTooManyRequests,
+	"api_error":             http.StatusInternalServerError,
+	"overloaded_error":      529,
+}
+
+// Server-sent event of the streamed message, only the fields we use.
+// message_start carries the Message without content, content_block_delta the pieces of the text
+// and message_delta the stop reason with output tokens so far.
+type anthropicStreamEvent struct {
+	Type    string             `json:"type"`
+	Message *anthropicResponse `json:"message,omitempty"`
+	Delta   struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage,omitempty"`
+	Error *anthropicError `json:"error,omitempty"`
+}
+
+// Concatenated text of all text blocks in the response.
+func (r anthropicResponse) Text() string {
+	var b strings.Builder
+	for _, block := range r.Content {
+		if block.Type == "text" {
+			b.WriteString(block.Text)
+		}
+	}
+	return b.String()
+}
+
+func (r anthropicResponse) usage() Usage {
+	return Usage{PromptTokens: r.Usage.InputTokens, CompletionTokens: r.Usage.OutputTokens}
+}
+
+// User message with the JPEG image followed by the text. Without the image it is plain text message.
+func anthropicImageMessage(text, imageBase64 string) anthropicMessage {
+	if imageBase64 == "" {
+		return anthropicTextMessage("user", text)
+	}
+	return anthropicMessage{
+		Role: "user",
+		Content: []anthropicContentBlock{
+			{
+				Type: "image",
+				Source: &anthropicImageSource{
+					Type:      "base64",
+					MediaType: "image/jpeg",
+					Data:      imageBase64,
+				},
+			},
+			{Type: "text", Text: text},
+		},
+	}
+}
+
+func anthropicTextMessage(role, text string) anthropicMessage {
+	return anthropicMessage{
+		Role:    role,
+		Content: []anthropicContentBlock{{Type: "text", Text: text}},
+	}
+}
+
+func (anthropicProvider) Describe(ctx context.Context, service Service, req DescribeRequest) (Completion, error) {
+	message := anthropicImageMessage(req.Prompt, req.ImageBase64)
+	resp, err := anthropicCreateMessage(ctx, service, newAnthropicRequest(req.Model, req.Sampling, message))
+	if err != nil {
+		return Completion{}, err
+	}
+
+	return Completion{Text: resp.Text(), Model: resp.Model, Usage: resp.usage()}, nil
+}
+
+// Answer follows the same reflection -> decision flow as the OpenAI version.
+// Reflection is streamed when OnReflectionToken is set. Structured decision is not supported,
+// decision always comes back as plain text.
+func (anthropicProvider) Answer(ctx context.Context, service Service, req AnswerRequest) (Answer, error) {
+	var answer Answer
+	reflectionReq := newAnthropicRequest(req.Model, req.Sampling,
+		anthropicImageMessage(req.ReflectionPrompt, req.ImageBase64),
+	)
+	var reflectionResp anthropicResponse
+	var err error
+	if req.OnReflectionToken != nil {
+		reflectionResp, err = anthropicCreateMessageStream(ctx, service, reflectionReq, req.OnReflectionToken)
+	} else {
+		reflectionResp, err = anthropicCreateMessage(ctx, service, reflectionReq)
+	}
+	if err != nil {
+		return answer, err
+	}
+	answer.Reflection = reflectionResp.Text()
+	answer.Usage = reflectionResp.usage()
+	log.Printf("AI sent reflection: %s\n", answer.Reflection)
+
+	decisionResp, err := anthropicCreateMessage(ctx, service, newAnthropicRequest(req.Model, req.Sampling,
+		anthropicImageMessage(req.ReflectionPrompt, req.ImageBase64),
+		anthropicTextMessage("assistant", answer.Reflection),
+		anthropicTextMessage("user", req.DecisionPrompt),
+	))
+	if err != nil {
+		return answer, err
+	}
+	answer.Decision = decisionResp.Text()
+	answer.Usage = answer.Usage.Add(decisionResp.usage())
+	log.Printf("AI sent decided: %s\n", answer.Decision)
+	return answer, nil
+}
+
+// Send the request to the /v1/messages endpoint of the Service.
+func anthropicCreateMessage(ctx context.Context, service Service, request anthropicRequest) (anthropicResponse, error) {
+	var response anthropicResponse
+	resp, err := anthropicPost(ctx, service, request)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return response, fmt.Errorf("failed to read anthropic response: %w", err)
+	}
+
+	err = json.Unmarshal(respBody, &response)
+	if err != nil && resp.StatusCode != http.StatusOK {
+		return response, &StatusError{Provider: "anthropic", StatusCode: resp.StatusCode, Message: string(respBody)}
+	}
+	if err != nil {
+		return response, fmt.Errorf("failed to decode anthropic response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || response.Error != nil {
+		return response, anthropicStatusError(resp.StatusCode, response.Error)
+	}
+
+	return response, nil
+}
+
+// Send streaming request to the /v1/messages endpoint of the Service, onToken is called with each piece
+// of the text. Returned response is put together from the events, with usage and the whole text as one block.
+func anthropicCreateMessageStream(ctx context.Context, service Service, request anthropicRequest, onToken func(string)) (anthropicResponse, error) {
+	var response anthropicResponse
+	request.Stream = true
+	resp, err := anthropicPost(ctx, service, request)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		var errResponse anthropicResponse
+		if json.Unmarshal(respBody, &errResponse) == nil && errResponse.Error != nil {
+			return response, anthropicStatusError(resp.StatusCode, errResponse.Error)
+		}
+		return response, &StatusError{Provider: "anthropic", StatusCode: resp.StatusCode, Message: string(respBody)}
+	}
+
+	var text strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data:")
+		if !found { // event names, comments and blank lines between the events
+			continue
+		}
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return response, fmt.Errorf("failed to decode anthropic stream: %w", err)
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				response = *event.Message
+			}
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				text.WriteString(event.Delta.Text)
+				onToken(event.Delta.Text)
+			}
+		case "message_delta":
+			response.StopReason = event.Delta.StopReason
+			if event.Usage != nil {
+				response.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			response.Content = []anthropicContentBlock{{Type: "text", Text: text.String()}}
+			return response, nil
+		case "error":
+			// Stream already answered 200 OK, so the status comes from the type of the error.
+			status := http.StatusInternalServerError
+			if event.Error != nil && anthropicErrorStatus[event.Error.Type] != 0 {
+				status = anthropicErrorStatus[event.Error.Type]
+			}
+			return response, anthropicStatusError(status, event.Error)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return response, fmt.Errorf("failed to read anthropic stream: %w", err)
+	}
+	return response, fmt.Errorf("anthropic stream ended before message_stop")
+}
+
+// POST the request to the /v1/messages endpoint of the Service with the API key and version.
+func anthropicPost(ctx context.Context, service Service, request anthropicRequest) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal anthropic request: %w", err)
+	}
+
+	baseURL := anthropicBaseURL
+	if service.URL != "" {
+		baseURL = strings.TrimSuffix(service.URL, "/")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create anthropic request: %w", err)
+	}
+	token, err := service.Token.reveal()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", token)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic request failed: %w", err)
+	}
+	return resp, nil
+}
+
+func anthropicStatusError(statusCode int, apiErr *anthropicError) *StatusError {
+	statusErr := &StatusError{Provider: "anthropic", StatusCode: statusCode}
+	if apiErr != nil {
+		statusErr.Message = apiErr.Type + ": " + apiErr.Message
+	}
+	return statusErr
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Body of streamed Messages API response, events as sent by Anthropic.
+const anthropicStreamBody = `event: message_start
+data: {"type": "message_start", "message": {"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-test-20250101", "content": [], "stop_reason": null, "usage": {"input_tokens": 900, "output_tokens": 1}}}
+
+event: content_block_start
+data: {"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}
+
+event: ping
+data: {"type": "ping"}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "The suspect "}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "reads a lot."}}
+
+event: content_block_stop
+data: {"type": "content_block_stop", "index": 0}
+
+event: message_delta
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn", "stop_sequence": null}, "usage": {"output_tokens": 6}}
+
+event: message_stop
+data: {"type": "message_stop"}
+
+`
+
+// Stand-in for Anthropic /v1/messages. Streamed requests get the stream, others the decision.
+func newAnthropicStandIn(t *testing.T, stream string) (*[]anthropicRequest, Service) {
+	var requests []anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "sk-ant-test" || r.Header.Get("anthropic-version") != anthropicAPIVersion {
+			t.Errorf("unexpected request %s with headers %v", r.URL.Path, r.Header)
+		}
+		var request anthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("could not decode request: %v", err)
+		}
+		requests = append(requests, request)
+		if request.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, stream)
+			return
+		}
+		fmt.Fprint(w, `{"id": "msg_2", "model": "claude-test-20250101", "content": [{"type": "text", "text": "YES"}],
+			"stop_reason": "end_turn", "usage": {"input_tokens": 910, "output_tokens": 2}}`)
+	}))
+	t.Cleanup(server.Close)
+	return &requests, Service{Name: "Anthropic", APIStyle: apiStyleAnthropic, URL: server.URL + "/", Token: "sk-ant-test"}
+}
+
+func TestAnthropicAnswerStreaming(t *testing.T) {
+	requests, service := newAnthropicStandIn(t, anthropicStreamBody)
+
+	var tokens []string
+	answer, err := anthropicProvider{}.Answer(context.Background(), service, AnswerRequest{
+		Model:             "claude-test",
+		ReflectionPrompt:  "Does the suspect like books?",
+		DecisionPrompt:    "Answer YES or NO.",
+		OnReflectionToken: func(token string) { tokens = append(tokens, token) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tokens, "|") != "The suspect |reads a lot." {
+		t.Errorf("streamed tokens = %q", tokens)
+	}
+	want := Answer{Reflection: "The suspect reads a lot.", Decision: "YES", Usage: Usage{PromptTokens: 1810, CompletionTokens: 8}}
+	if answer != want {
+		t.Errorf("Answer() = %+v, want %+v", answer, want)
+	}
+
+	if len(*requests) != 2 {
+		t.Fatalf("got %d requests, want reflection and decision", len(*requests))
+	}
+	reflection, decision := (*requests)[0], (*requests)[1]
+	if !reflection.Stream || decision.Stream {
+		t.Errorf("stream of reflection %v and decision %v, want only the reflection streamed", reflection.Stream, decision.Stream)
+	}
+	if len(decision.Messages) != 3 || decision.Messages[1].Content[0].Text != want.Reflection {
+		t.Errorf("decision messages = %+v, want the streamed reflection before the decision prompt", decision.Messages)
+	}
+}
+
+func TestAnthropicAnswerWithoutStreaming(t *testing.T) {
+	requests, service := newAnthropicStandIn(t, "")
+	answer, err := anthropicProvider{}.Answer(context.Background(), service, AnswerRequest{
+		Model:            "claude-test",
+		ReflectionPrompt: "Is the suspect a student?",
+		DecisionPrompt:   "Answer YES or NO.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Reflection != "YES" || (*requests)[0].Stream {
+		t.Errorf("Answer() = %+v with stream %v, want plain reflection", answer, (*requests)[0].Stream)
+	}
+}
+
+func TestAnthropicStreamErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    int
+		message string
+	}{
+		{"error status", http.StatusTooManyRequests, `{"type": "error", "error": {"type": "rate_limit_error", "message": "slow down"}}`,
+			http.StatusTooManyRequests, "rate_limit_error: slow down"},
+		// Errors in the stream come after 200 OK, they must still be retried and counted by the circuit breaker.
+		{"error event", http.StatusOK, strings.SplitAfter(anthropicStreamBody, "reads a lot.\"}}\n\n")[0] +
+			"event: error\ndata: {\"type\": \"error\", \"error\": {\"type\": \"overloaded_error\", \"message\": \"Overloaded\"}}\n\n",
+			529, "overloaded_error: Overloaded"},
+		{"unknown error event", http.StatusOK, "event: error\ndata: {\"type\": \"error\", \"error\": {\"type\": \"new_error\", \"message\": \"Oops\"}}\n\n",
+			http.StatusInternalServerError, "new_error: Oops"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			t.Cleanup(server.Close)
+			service := Service{Name: "Anthropic", APIStyle: apiStyleAnthropic, URL: server.URL, Token: "sk-ant-test"}
+
+			var tokens []string
+			_, err := anthropicCreateMessageStream(context.Background(), service, anthropicRequest{Model: "claude-test"},
+				func(token string) { tokens = append(tokens, token) })
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("error = %v, want StatusError", err)
+			}
+			if statusErr.StatusCode != tt.want || statusErr.Message != tt.message {
+				t.Errorf("StatusError = %d %q, want %d %q", statusErr.StatusCode, statusErr.Message, tt.want, tt.message)
+			}
+			if !retryable(err) {
+				t.Errorf("retryable(%v) = false, want true", err)
+			}
+		})
+	}
+
+	t.Run("stream cut", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, strings.Split(anthropicStreamBody, "event: message_stop")[0])
+		}))
+		t.Cleanup(server.Close)
+		service := Service{Name: "Anthropic", APIStyle: apiStyleAnthropic, URL: server.URL, Token: "sk-ant-test"}
+		_, err := anthropicCreateMessageStream(context.Background(), service, anthropicRequest{Model: "claude-test"}, func(string) {})
+		if err == nil || !strings.Contains(err.Error(), "message_stop") {
+			t.Errorf("error = %v, want stream ended before message_stop", err)
+		}
+	})
+}
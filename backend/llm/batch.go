@@ -0,0 +1,196 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Batch API of OpenAI: requests are uploaded as JSONL file, processed within 24 hours for half the price,
+// and results are downloaded as another JSONL file. Only Services with openai API style support it.
+
+// Statuses of the batch as reported by the API, the batch is finished in any of these.
+var BatchFinalStatuses = []string{"completed", "failed", "expired", "cancelled"}
+
+// One describe request of the batch. CustomID pairs it with its result.
+type BatchDescribeRequest struct {
+	CustomID string
+	DescribeRequest
+}
+
+// Result of one request of the batch. Err is set when the request failed.
+type BatchResult struct {
+	CustomID   string
+	Completion Completion
+	Err        string
+}
+
+// Batch as reported by the API.
+type BatchStatus struct {
+	ID           string
+	Status       string
+	Total        int
+	Completed    int
+	Failed       int
+	OutputFileID string
+	ErrorFileID  string
+}
+
+func (b BatchStatus) Finished() bool {
+	for _, status := range BatchFinalStatuses {
+		if b.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the JSONL input file of the batch for the Service.
+func BuildDescribeBatch(service Service, requests []BatchDescribeRequest) []byte {
+	var file openai.UploadBatchFileRequest
+	for _, req := range requests {
+		file.AddChatCompletion(req.CustomID, openaiDescribeRequest(service, req.DescribeRequest))
+	}
+	return file.MarshalJSONL()
+}
+
+// Upload the JSONL input file and create the batch from it. Returns ID of the batch.
+func SubmitBatch(ctx context.Context, service Service, jsonl []byte) (string, error) {
+	if err := checkBatchService(service); err != nil {
+		return "", err
+	}
+	client, err := openaiClient(service)
+	if err != nil {
+		return "", err
+	}
+	file, err := client.CreateFileBytes(ctx, openai.FileBytesRequest{
+		Name:    "describe_batch.jsonl",
+		Bytes:   jsonl,
+		Purpose: openai.PurposeBatch,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not upload batch file: %w", err)
+	}
+	batch, err := client.CreateBatch(ctx, openai.CreateBatchRequest{
+		InputFileID: file.ID,
+		Endpoint:    openai.BatchEndpointChatCompletions,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not create batch: %w", err)
+	}
+	return batch.ID, nil
+}
+
+// Get the current status of the batch.
+func GetBatch(ctx context.Context, service Service, batchID string) (BatchStatus, error) {
+	if err := checkBatchService(service); err != nil {
+		return BatchStatus{}, err
+	}
+	client, err := openaiClient(service)
+	if err != nil {
+		return BatchStatus{}, err
+	}
+	batch, err := client.RetrieveBatch(ctx, batchID)
+	if err != nil {
+		return BatchStatus{}, fmt.Errorf("could not get batch %s: %w", batchID, err)
+	}
+	status := BatchStatus{
+		ID:        batch.ID,
+		Status:    batch.Status,
+		Total:     batch.RequestCounts.Total,
+		Completed: batch.RequestCounts.Completed,
+		Failed:    batch.RequestCounts.Failed,
+	}
+	if batch.OutputFileID != nil {
+		status.OutputFileID = *batch.OutputFileID
+	}
+	if batch.ErrorFileID != nil {
+		status.ErrorFileID = *batch.ErrorFileID
+	}
+	return status, nil
+}
+
+// Download the output or error file of the batch.
+func DownloadBatchFile(ctx context.Context, service Service, fileID string) (io.ReadCloser, error) {
+	client, err := openaiClient(service)
+	if err != nil {
+		return nil, err
+	}
+	content, err := client.GetFileContent(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("could not download batch file %s: %w", fileID, err)
+	}
+	return content, nil
+}
+
+type batchOutputLine struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int                           `json:"status_code"`
+		Body       openai.ChatCompletionResponse `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Parse the output (or error) file of the batch, as downloaded from the API.
+func ParseBatchResults(r io.Reader) ([]BatchResult, error) {
+	var results []BatchResult
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) // lines with long completions
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var line batchOutputLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return results, fmt.Errorf("could not parse line %d of batch results: %w", lineNumber, err)
+		}
+
+		result := BatchResult{CustomID: line.CustomID}
+		switch {
+		case line.Error != nil:
+			result.Err = fmt.Sprintf("%s: %s", line.Error.Code, line.Error.Message)
+		case line.Response == nil:
+			result.Err = "no response"
+		case line.Response.StatusCode != 200:
+			result.Err = fmt.Sprintf("status %d", line.Response.StatusCode)
+		case len(line.Response.Body.Choices) == 0:
+			result.Err = "no choices"
+		default:
+			body := line.Response.Body
+			result.Completion = Completion{
+				Text:  body.Choices[0].Message.Content,
+				Model: body.Model,
+				Usage: openaiUsage(body.Usage),
+			}
+		}
+		results = append(results, result)
+	}
+	return results, scanner.Err()
+}
+
+func checkBatchService(service Service) error {
+	if service.APIStyle != "" && service.APIStyle != apiStyleOpenAI || service.APIStyle == "" && service.IsLocal() {
+		return fmt.Errorf("service %s does not support Batch API, only %s API style does", service.Name, apiStyleOpenAI)
+	}
+	return nil
+}
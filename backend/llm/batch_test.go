@@ -0,0 +1,156 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestBuildDescribeBatch(t *testing.T) {
+	jsonl := BuildDescribeBatch(Service{Name: "OpenAI"}, []BatchDescribeRequest{
+		{CustomID: "job-1", DescribeRequest: DescribeRequest{Model: "gpt-4o", Prompt: "Describe.", ImageBase64: "aW1n", Sampling: Sampling{Seed: intPtr(3)}}},
+		{CustomID: "job-2", DescribeRequest: DescribeRequest{Model: "gpt-4o", Prompt: "Describe.", ImageBase64: "aW1o"}},
+	})
+	lines := strings.Split(strings.TrimSpace(string(jsonl)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	var first struct {
+		CustomID string                       `json:"custom_id"`
+		Method   string                       `json:"method"`
+		URL      string                       `json:"url"`
+		Body     openai.ChatCompletionRequest `json:"body"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.CustomID != "job-1" || first.Method != "POST" || first.URL != "/v1/chat/completions" || first.Body.Model != "gpt-4o" {
+		t.Errorf("first line = %+v, want POST of job-1 to chat completions", first)
+	}
+	if first.Body.Seed == nil || *first.Body.Seed != 3 {
+		t.Errorf("seed = %v, want the sampling of the request", first.Body.Seed)
+	}
+	messages := first.Body.Messages
+	if len(messages) != 2 || messages[0].Content != "Describe." || len(messages[1].MultiContent) != 1 ||
+		messages[1].MultiContent[0].ImageURL.URL != "data:image/jpeg;base64,aW1n" {
+		t.Errorf("messages = %+v, want the prompt and the image", messages)
+	}
+}
+
+func TestBatchAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer sk-test" {
+			t.Errorf("Authorization header = %q, want the token", auth)
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/files":
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				t.Errorf("no file uploaded: %v", err)
+				return
+			}
+			content, _ := io.ReadAll(file)
+			if string(content) != "{}\n" || r.FormValue("purpose") != "batch" {
+				t.Errorf("uploaded %q for %q, want the batch file", content, r.FormValue("purpose"))
+			}
+			fmt.Fprint(w, `{"id": "file-in"}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/batches":
+			fmt.Fprint(w, `{"id": "batch-1", "status": "validating"}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/batches/batch-1":
+			fmt.Fprint(w, `{"id": "batch-1", "status": "expired", "output_file_id": "file-out", "error_file_id": "file-err",
+				"request_counts": {"total": 5, "completed": 3, "failed": 1}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/files/file-err/content":
+			fmt.Fprint(w, "error lines")
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	service := Service{Name: "OpenAI", APIStyle: apiStyleOpenAI, URL: server.URL, Token: "sk-test"}
+	ctx := context.Background()
+
+	id, err := SubmitBatch(ctx, service, []byte("{}\n"))
+	if err != nil || id != "batch-1" {
+		t.Fatalf("SubmitBatch() = %q, %v, want batch-1", id, err)
+	}
+
+	status, err := GetBatch(ctx, service, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BatchStatus{ID: "batch-1", Status: "expired", Total: 5, Completed: 3, Failed: 1, OutputFileID: "file-out", ErrorFileID: "file-err"}
+	if status != want || !status.Finished() {
+		t.Errorf("GetBatch() = %+v, want finished %+v", status, want)
+	}
+
+	content, err := DownloadBatchFile(ctx, service, status.ErrorFileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	if data, _ := io.ReadAll(content); string(data) != "error lines" {
+		t.Errorf("DownloadBatchFile() = %q", data)
+	}
+
+	ollama := Service{Name: "Ollama", APIStyle: apiStyleOllama, URL: server.URL}
+	if _, err := SubmitBatch(ctx, ollama, nil); err == nil {
+		t.Error("SubmitBatch() to ollama service succeeded, want error")
+	}
+	if _, err := GetBatch(ctx, Service{Name: "Local", Type: TypeLocal}, id); err == nil {
+		t.Error("GetBatch() of local service succeeded, want error")
+	}
+}
+
+func TestParseBatchResults(t *testing.T) {
+	file := strings.Join([]string{
+		`{"custom_id": "ok", "response": {"status_code": 200, "body": {"model": "gpt-4o-2024", "choices": [{"message": {"role": "assistant", "content": "A calm man."}}], "usage": {"prompt_tokens": 900, "completion_tokens": 30}}}}`,
+		``,
+		`{"custom_id": "failed", "response": null, "error": {"code": "server_error", "message": "try again"}}`,
+		`{"custom_id": "status", "response": {"status_code": 429, "body": {}}}`,
+		`{"custom_id": "empty", "response": {"status_code": 200, "body": {"choices": []}}}`,
+		`{"custom_id": "missing"}`,
+	}, "\n")
+	results, err := ParseBatchResults(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BatchResult{
+		{CustomID: "ok", Completion: Completion{Text: "A calm man.", Model: "gpt-4o-2024", Usage: Usage{PromptTokens: 900, CompletionTokens: 30}}},
+		{CustomID: "failed", Err: "server_error: try again"},
+		{CustomID: "status", Err: "status 429"},
+		{CustomID: "empty", Err: "no choices"},
+		{CustomID: "missing", Err: "no response"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+
+	results, err = ParseBatchResults(strings.NewReader(`{"custom_id": "ok", "response": {"status_code": 200, "body": {}}}` + "\nnot json\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") || len(results) != 1 {
+		t.Errorf("ParseBatchResults() of malformed line = %d results, %v, want error of line 2", len(results), err)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	apiStyleOllama string = "ollama"
+	ollamaBaseURL  string = "http://localhost:11434"
+)
+
+func init() {
+	Register(apiStyleOllama, ollamaProvider{})
+}
+
+// Provider for local models served by Ollama or anything else speaking its /api/chat endpoint.
+// If Service defines non-empty URL, it is used instead of the default local Ollama address.
+// Token is optional, when set it is sent as Bearer token for Ollama running behind a proxy.
+type ollamaProvider struct{}
+
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // base64 encoded images, for multimodal models
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   json.RawMessage `json:"format,omitempty"` // JSON schema for structured output
+	Options  *ollamaOptions  `json:"options,omitempty"`
+}
+
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"` // max tokens
+}
+
+type ollamaResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error,omitempty"`
+}
+
+func (r ollamaResponse) usage() Usage {
+	return Usage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
+
+func (ollamaProvider) Describe(ctx context.Context, service Service, req DescribeRequest) (Completion, error) {
+	resp, err := ollamaChat(ctx, service, ollamaRequest{
+		Model: req.Model,
+		Messages: []ollamaMessage{
+			{
+				Role:    "user",
+				Content: req.Prompt,
+				Images:  []string{req.ImageBase64},
+			},
+		},
+		Options: ollamaSampling(req.Sampling),
+	})
+	if err != nil {
+		return Completion{}, err
+	}
+
+	return Completion{Text: resp.Message.Content, Model: resp.Model, Usage: resp.usage()}, nil
+}
+
+// Answer follows the same reflection -> decision flow as the OpenAI version.
+func (ollamaProvider) Answer(ctx context.Context, service Service, req AnswerRequest) (Answer, error) {
+	var answer Answer
+	reflectionReq := ollamaRequest{
+		Model: req.Model,
+		Messages: []ollamaMessage{
+			ollamaReflectionMessage(req),
+		},
+		Options: ollamaSampling(req.Sampling),
+	}
+	var reflectionResp ollamaResponse
+	var err error
+	if req.OnReflectionToken != nil {
+		reflectionResp, err = ollamaChatStream(ctx, service, reflectionReq, req.OnReflectionToken)
+	} else {
+		reflectionResp, err = ollamaChat(ctx, service, reflectionReq)
+	}
+	if err != nil {
+		return answer, err
+	}
+	answer.Reflection = reflectionResp.Message.Content
+	answer.Usage = reflectionResp.usage()
+	log.Printf("AI sent reflection: %s\n", answer.Reflection)
+
+	decisionReq := ollamaRequest{
+		Model: req.Model,
+		Messages: []ollamaMessage{
+			ollamaReflectionMessage(req),
+			{Role: "assistant", Content: answer.Reflection},
+			{Role: "user", Content: req.DecisionPrompt},
+		},
+		Options: ollamaSampling(req.Sampling),
+	}
+	if req.StructuredDecision {
+		decisionReq.Format = DecisionSchema
+	}
+	decisionResp, err := ollamaChat(ctx, service, decisionReq)
+	if err != nil {
+		return answer, err
+	}
+	answer.Decision = decisionResp.Message.Content
+	answer.Usage = answer.Usage.Add(decisionResp.usage())
+	log.Printf("AI sent decided: %s\n", answer.Decision)
+	return answer, nil
+}
+
+// Send non-streaming request to the /api/chat endpoint of the Service.
+func ollamaChat(ctx context.Context, service Service, request ollamaRequest) (ollamaResponse, error) {
+	var response ollamaResponse
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal ollama request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaURL(service)+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return response, fmt.Errorf("failed to create ollama request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if !service.Token.IsEmpty() {
+		token, err := service.Token.reveal()
+		if err != nil {
+			return response, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return response, fmt.Errorf("ollama request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return response, fmt.Errorf("failed to read ollama response: %w", err)
+	}
+
+	err = json.Unmarshal(respBody, &response)
+	if err != nil && resp.StatusCode != http.StatusOK {
+		return response, &StatusError{Provider: "ollama", StatusCode: resp.StatusCode, Message: string(respBody)}
+	}
+	if err != nil {
+		return response, fmt.Errorf("failed to decode ollama response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || response.Error != "" {
+		return response, &StatusError{Provider: "ollama", StatusCode: resp.StatusCode, Message: response.Error}
+	}
+
+	return response, nil
+}
+
+// Send streaming request to the /api/chat endpoint of the Service, onToken is called with each piece
+// of the message. Returned response is the final one with usage and the whole message put together.
+func ollamaChatStream(ctx context.Context, service Service, request ollamaRequest, onToken func(string)) (ollamaResponse, error) {
+	var response ollamaResponse
+	request.Stream = true
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal ollama request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaURL(service)+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return response, fmt.Errorf("failed to create ollama request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if !service.Token.IsEmpty() {
+		token, err := service.Token.reveal()
+		if err != nil {
+			return response, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return response, fmt.Errorf("ollama request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return response, &StatusError{Provider: "ollama", StatusCode: resp.StatusCode, Message: string(respBody)}
+	}
+
+	var content strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk ollamaResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				return response, fmt.Errorf("ollama stream ended before done")
+			}
+			return response, fmt.Errorf("failed to decode ollama stream: %w", err)
+		}
+		if chunk.Error != "" {
+			return response, &StatusError{Provider: "ollama", StatusCode: resp.StatusCode, Message: chunk.Error}
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			onToken(chunk.Message.Content)
+		}
+		if chunk.Done {
+			response = chunk
+			response.Message.Content = content.String()
+			return response, nil
+		}
+	}
+}
+
+// First message of the answer, with the image of the suspect in vision witness mode.
+func ollamaReflectionMessage(req AnswerRequest) ollamaMessage {
+	message := ollamaMessage{Role: "user", Content: req.ReflectionPrompt}
+	if req.ImageBase64 != "" {
+		message.Images = []string{req.ImageBase64}
+	}
+	return message
+}
+
+func ollamaSampling(sampling Sampling) *ollamaOptions {
+	if sampling == (Sampling{}) {
+		return nil
+	}
+	return &ollamaOptions{
+		Temperature: sampling.Temperature,
+		TopP:        sampling.TopP,
+		Seed:        sampling.Seed,
+		NumPredict:  sampling.MaxTokens,
+	}
+}
+
+// Base URL of the Ollama server. URLs stored without scheme (localhost:11434) are treated as plain http.
+func ollamaURL(service Service) string {
+	baseURL := service.URL
+	if baseURL == "" {
+		return ollamaBaseURL
+	}
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "http://" + baseURL
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
@@ -0,0 +1,272 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Stand-in for Ollama /api/chat. Each request is recorded and answered by the next reply,
+// reply is written to the response as is.
+type ollamaStandIn struct {
+	t        *testing.T
+	mu       sync.Mutex
+	requests []ollamaRequest
+	headers  []http.Header
+	replies  []func(w http.ResponseWriter)
+}
+
+func newOllamaStandIn(t *testing.T, replies ...func(w http.ResponseWriter)) (*ollamaStandIn, Service) {
+	s := &ollamaStandIn{t: t, replies: replies}
+	server := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(server.Close)
+	// Stored URLs often lack the scheme, see ollamaURL().
+	return s, Service{Name: "Ollama", APIStyle: apiStyleOllama, Type: TypeLocal, URL: strings.TrimPrefix(server.URL, "http://")}
+}
+
+func (s *ollamaStandIn) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Method != http.MethodPost || r.URL.Path != "/api/chat" {
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var request ollamaRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.t.Errorf("could not decode request: %v", err)
+	}
+	s.requests = append(s.requests, request)
+	s.headers = append(s.headers, r.Header.Clone())
+	if len(s.requests) > len(s.replies) {
+		s.t.Errorf("unexpected request number %d", len(s.requests))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.replies[len(s.requests)-1](w)
+}
+
+// Reply with single JSON object.
+func ollamaReply(status int, response ollamaResponse) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// Reply with newline delimited JSON chunks like streaming Ollama does.
+func ollamaStreamReply(chunks ...ollamaResponse) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, chunk := range chunks {
+			json.NewEncoder(w).Encode(chunk)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func intPtr(i int) *int { return &i }
+
+func floatPtr(f float64) *float64 { return &f }
+
+func TestOllamaDescribe(t *testing.T) {
+	standIn, service := newOllamaStandIn(t, ollamaReply(http.StatusOK, ollamaResponse{
+		Model:           "llava:13b",
+		Message:         ollamaMessage{Role: "assistant", Content: "A man with a beard."},
+		Done:            true,
+		PromptEvalCount: 700,
+		EvalCount:       40,
+	}))
+	service.Token = "proxy-token"
+
+	completion, err := ollamaProvider{}.Describe(context.Background(), service, DescribeRequest{
+		Model:       "llava",
+		Prompt:      "Describe the person.",
+		ImageBase64: "aW1hZ2U=",
+		Sampling:    Sampling{Temperature: floatPtr(0.2), Seed: intPtr(7), MaxTokens: intPtr(500)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Completion{Text: "A man with a beard.", Model: "llava:13b", Usage: Usage{PromptTokens: 700, CompletionTokens: 40}}
+	if completion != want {
+		t.Errorf("Describe() = %+v, want %+v", completion, want)
+	}
+
+	request := standIn.requests[0]
+	if request.Model != "llava" || request.Stream {
+		t.Errorf("request model %q stream %v, want llava without stream", request.Model, request.Stream)
+	}
+	if len(request.Messages) != 1 || request.Messages[0].Content != "Describe the person." ||
+		len(request.Messages[0].Images) != 1 || request.Messages[0].Images[0] != "aW1hZ2U=" {
+		t.Errorf("request messages = %+v, want the prompt with the image", request.Messages)
+	}
+	options := request.Options
+	if options == nil || *options.Temperature != 0.2 || *options.Seed != 7 || *options.NumPredict != 500 || options.TopP != nil {
+		t.Errorf("request options = %+v, want temperature 0.2, seed 7 and num_predict 500", options)
+	}
+	if auth := standIn.headers[0].Get("Authorization"); auth != "Bearer proxy-token" {
+		t.Errorf("Authorization header = %q, want the token", auth)
+	}
+}
+
+func TestOllamaAnswer(t *testing.T) {
+	standIn, service := newOllamaStandIn(t,
+		ollamaReply(http.StatusOK, ollamaResponse{
+			Message:         ollamaMessage{Role: "assistant", Content: "The suspect looks like a pizza lover."},
+			Done:            true,
+			PromptEvalCount: 100,
+			EvalCount:       10,
+		}),
+		ollamaReply(http.StatusOK, ollamaResponse{
+			Message:         ollamaMessage{Role: "assistant", Content: `{"answer":"YES"}`},
+			Done:            true,
+			PromptEvalCount: 120,
+			EvalCount:       5,
+		}),
+	)
+
+	answer, err := ollamaProvider{}.Answer(context.Background(), service, AnswerRequest{
+		Model:              "llama3",
+		ReflectionPrompt:   "Does the suspect like pizza?",
+		DecisionPrompt:     "Answer YES or NO.",
+		StructuredDecision: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Answer{
+		Reflection: "The suspect looks like a pizza lover.",
+		Decision:   `{"answer":"YES"}`,
+		Usage:      Usage{PromptTokens: 220, CompletionTokens: 15},
+	}
+	if answer != want {
+		t.Errorf("Answer() = %+v, want %+v", answer, want)
+	}
+
+	if len(standIn.requests) != 2 {
+		t.Fatalf("got %d requests, want reflection and decision", len(standIn.requests))
+	}
+	reflection, decision := standIn.requests[0], standIn.requests[1]
+	if reflection.Stream || reflection.Format != nil || len(reflection.Messages[0].Images) != 0 {
+		t.Errorf("reflection request = %+v, want no stream, format or image", reflection)
+	}
+	if reflection.Options != nil {
+		t.Errorf("reflection options = %+v, want none when sampling is not set", reflection.Options)
+	}
+	roles := make([]string, len(decision.Messages))
+	for i, m := range decision.Messages {
+		roles[i] = m.Role
+	}
+	if strings.Join(roles, ",") != "user,assistant,user" || decision.Messages[1].Content != want.Reflection {
+		t.Errorf("decision messages = %+v, want the reflection before the decision prompt", decision.Messages)
+	}
+	var schema bytes.Buffer
+	if err := json.Compact(&schema, DecisionSchema); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decision.Format, schema.Bytes()) {
+		t.Errorf("decision format = %s, want DecisionSchema", decision.Format)
+	}
+}
+
+func TestOllamaAnswerStreaming(t *testing.T) {
+	standIn, service := newOllamaStandIn(t,
+		ollamaStreamReply(
+			ollamaResponse{Message: ollamaMessage{Role: "assistant", Content: "The suspect "}},
+			ollamaResponse{Message: ollamaMessage{Role: "assistant", Content: "wears glasses."}},
+			ollamaResponse{Done: true, PromptEvalCount: 800, EvalCount: 4},
+		),
+		ollamaReply(http.StatusOK, ollamaResponse{Message: ollamaMessage{Content: "NO"}, Done: true, PromptEvalCount: 810, EvalCount: 1}),
+	)
+
+	var tokens []string
+	answer, err := ollamaProvider{}.Answer(context.Background(), service, AnswerRequest{
+		Model:             "llava",
+		ReflectionPrompt:  "Is the suspect a student?",
+		DecisionPrompt:    "Answer YES or NO.",
+		ImageBase64:       "cG9ydHJhaXQ=",
+		OnReflectionToken: func(token string) { tokens = append(tokens, token) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tokens, "|") != "The suspect |wears glasses." {
+		t.Errorf("streamed tokens = %q", tokens)
+	}
+	want := Answer{Reflection: "The suspect wears glasses.", Decision: "NO", Usage: Usage{PromptTokens: 1610, CompletionTokens: 5}}
+	if answer != want {
+		t.Errorf("Answer() = %+v, want %+v", answer, want)
+	}
+
+	reflection, decision := standIn.requests[0], standIn.requests[1]
+	if !reflection.Stream || decision.Stream {
+		t.Errorf("stream of reflection %v and decision %v, want only the reflection streamed", reflection.Stream, decision.Stream)
+	}
+	for i, request := range standIn.requests {
+		if images := request.Messages[0].Images; len(images) != 1 || images[0] != "cG9ydHJhaXQ=" {
+			t.Errorf("request %d images = %q, want the portrait in vision witness mode", i, images)
+		}
+	}
+	if decision.Format != nil {
+		t.Errorf("decision format = %s, want none without StructuredDecision", decision.Format)
+	}
+}
+
+func TestOllamaErrorStatus(t *testing.T) {
+	plain := func(status int, body string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			w.WriteHeader(status)
+			fmt.Fprint(w, body)
+		}
+	}
+	tests := []struct {
+		name    string
+		reply   func(w http.ResponseWriter)
+		stream  bool
+		status  int
+		message string
+	}{
+		{"json error", ollamaReply(http.StatusNotFound, ollamaResponse{Error: `model "llava" not found`}), false, http.StatusNotFound, `model "llava" not found`},
+		{"plain error", plain(http.StatusBadGateway, "bad gateway"), false, http.StatusBadGateway, "bad gateway"},
+		{"error with ok status", ollamaReply(http.StatusOK, ollamaResponse{Error: "out of memory"}), false, http.StatusOK, "out of memory"},
+		{"stream error status", plain(http.StatusServiceUnavailable, "loading model"), true, http.StatusServiceUnavailable, "loading model"},
+		{"stream error chunk", ollamaStreamReply(ollamaResponse{Message: ollamaMessage{Content: "The"}}, ollamaResponse{Error: "context canceled"}), true, http.StatusOK, "context canceled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, service := newOllamaStandIn(t, tt.reply)
+			req := AnswerRequest{Model: "llava", ReflectionPrompt: "Question?", DecisionPrompt: "YES or NO?"}
+			if tt.stream {
+				req.OnReflectionToken = func(string) {}
+			}
+			_, err := ollamaProvider{}.Answer(context.Background(), service, req)
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("Answer() error = %v, want StatusError", err)
+			}
+			if statusErr.StatusCode != tt.status || statusErr.Message != tt.message {
+				t.Errorf("StatusError = %d %q, want %d %q", statusErr.StatusCode, statusErr.Message, tt.status, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const apiStyleOpenAI string = "openai"
+
+func init() {
+	Register(apiStyleOpenAI, openaiProvider{})
+}
+
+// Provider for OpenAI styled API.
+// If Service defines non-empty URL, it is used as BaseURL for the OpenAI client - this allows usage of LLM proxies
+// or other services compatible with OpenAI styled API.
+type openaiProvider struct{}
+
+func (openaiProvider) Describe(ctx context.Context, service Service, req DescribeRequest) (Completion, error) {
+	client, err := openaiClient(service)
+	if err != nil {
+		return Completion{}, err
+	}
+	resp, err := client.CreateChatCompletion(ctx, openaiDescribeRequest(service, req))
+	if err != nil {
+		return Completion{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Completion{}, fmt.Errorf("openai returned no choices for model %s", req.Model)
+	}
+
+	return Completion{Text: resp.Choices[0].Message.Content, Model: resp.Model, Usage: openaiUsage(resp.Usage)}, nil
+}
+
+func (openaiProvider) Answer(ctx context.Context, service Service, req AnswerRequest) (Answer, error) {
+	var answer Answer
+	client, err := openaiClient(service)
+	if err != nil {
+		return answer, err
+	}
+	reflectionReq := openai.ChatCompletionRequest{
+		Model:    req.Model,
+		Messages: []openai.ChatCompletionMessage{openaiReflectionMessage(req)},
+	}
+	openaiSampling(service, &reflectionReq, req.Sampling)
+	if req.OnReflectionToken != nil {
+		reflection, usage, err := openaiStream(ctx, client, service, reflectionReq, req.OnReflectionToken)
+		if err != nil {
+			return answer, err
+		}
+		answer.Reflection = reflection
+		answer.Usage = usage
+	} else {
+		reflectionResp, err := client.CreateChatCompletion(ctx, reflectionReq)
+		if err != nil {
+			return answer, err
+		}
+		if len(reflectionResp.Choices) == 0 {
+			return answer, fmt.Errorf("openai returned no choices for model %s", req.Model)
+		}
+		answer.Reflection = reflectionResp.Choices[0].Message.Content
+		answer.Usage = openaiUsage(reflectionResp.Usage)
+	}
+	log.Printf("AI sent reflection: %s\n", answer.Reflection)
+
+	decisionReq := openai.ChatCompletionRequest{
+		Model: req.Model,
+		Messages: []openai.ChatCompletionMessage{
+			openaiReflectionMessage(req),
+			{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: answer.Reflection,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: req.DecisionPrompt,
+			},
+		},
+	}
+	openaiSampling(service, &decisionReq, req.Sampling)
+	// Proxies and OpenAI-compatible services often do not support json_schema, use it only with OpenAI itself.
+	if req.StructuredDecision && service.URL == "" {
+		decisionReq.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   "decision",
+				Schema: DecisionSchema,
+				Strict: true,
+			},
+		}
+	}
+	decisionResp, err := client.CreateChatCompletion(ctx, decisionReq)
+	if err != nil {
+		return answer, err
+	}
+	if len(decisionResp.Choices) == 0 {
+		return answer, fmt.Errorf("openai returned no choices for model %s", req.Model)
+	}
+	answer.Decision = decisionResp.Choices[0].Message.Content
+	answer.Usage = answer.Usage.Add(openaiUsage(decisionResp.Usage))
+	log.Printf("AI sent decided: %s\n", answer.Decision)
+	return answer, nil
+}
+
+// Stream the completion, onToken is called with each piece of the message.
+// Returns the whole message put together and the usage, if the API sent it.
+func openaiStream(ctx context.Context, client *openai.Client, service Service, request openai.ChatCompletionRequest, onToken func(string)) (string, Usage, error) {
+	var usage Usage
+	request.Stream = true
+	if service.URL == "" { // same as json_schema, not all compatible services know stream_options
+		request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	stream, err := client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return "", usage, err
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return content.String(), usage, nil
+		}
+		if err != nil {
+			return content.String(), usage, err
+		}
+		if resp.Usage != nil {
+			usage = openaiUsage(*resp.Usage)
+		}
+		if len(resp.Choices) > 0 && resp.Choices[0].Delta.Content != "" {
+			content.WriteString(resp.Choices[0].Delta.Content)
+			onToken(resp.Choices[0].Delta.Content)
+		}
+	}
+}
+
+// First message of the answer, with the image of the suspect in vision witness mode.
+func openaiReflectionMessage(req AnswerRequest) openai.ChatCompletionMessage {
+	if req.ImageBase64 == "" {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: req.ReflectionPrompt}
+	}
+	return openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser,
+		MultiContent: []openai.ChatMessagePart{
+			{Type: openai.ChatMessagePartTypeText, Text: req.ReflectionPrompt},
+			{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL:    fmt.Sprintf("data:image/jpeg;base64,%s", req.ImageBase64),
+					Detail: openai.ImageURLDetailHigh,
+				},
+			},
+		},
+	}
+}
+
+// Describe request as sent to the chat completions endpoint, also used for lines of the batch file.
+func openaiDescribeRequest(service Service, req DescribeRequest) openai.ChatCompletionRequest {
+	request := openai.ChatCompletionRequest{
+		Model: req.Model,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: req.Prompt,
+			},
+			{
+				Role: openai.ChatMessageRoleUser,
+				MultiContent: []openai.ChatMessagePart{
+					{
+						Type: openai.ChatMessagePartTypeImageURL,
+						ImageURL: &openai.ChatMessageImageURL{
+							URL:    fmt.Sprintf("data:image/jpeg;base64,%s", req.ImageBase64),
+							Detail: openai.ImageURLDetailHigh,
+						},
+					},
+				},
+			},
+		},
+	}
+	openaiSampling(service, &request, req.Sampling)
+	return request
+}
+
+// Set the sampling parameters on the request.
+// The client leaves out zero temperature and top_p, so zero is sent as the smallest float instead.
+// OpenAI itself wants max_completion_tokens, compatible services mostly know only max_tokens.
+func openaiSampling(service Service, request *openai.ChatCompletionRequest, sampling Sampling) {
+	if sampling.Temperature != nil {
+		request.Temperature = max(float32(*sampling.Temperature), math.SmallestNonzeroFloat32)
+	}
+	if sampling.TopP != nil {
+		request.TopP = max(float32(*sampling.TopP), math.SmallestNonzeroFloat32)
+	}
+	if sampling.Seed != nil {
+		seed := *sampling.Seed
+		request.Seed = &seed
+	}
+	if sampling.MaxTokens != nil {
+		if service.URL == "" {
+			request.MaxCompletionTokens = *sampling.MaxTokens
+		} else {
+			request.MaxTokens = *sampling.MaxTokens
+		}
+	}
+}
+
+func openaiClient(service Service) (*openai.Client, error) {
+	token, err := service.Token.reveal()
+	if err != nil {
+		return nil, err
+	}
+	config := openai.DefaultConfig(token)
+	if service.URL != "" {
+		config.BaseURL = service.URL
+	}
+	return openai.NewClientWithConfig(config), nil
+}
+
+func openaiUsage(usage openai.Usage) Usage {
+	return Usage{PromptTokens: usage.PromptTokens, CompletionTokens: usage.CompletionTokens}
+}
@@ -0,0 +1,174 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+// Package llm holds the clients for LLM providers. Each provider implements
+// the Provider interface and registers itself under its API style,
+// database package then only picks the right one by Service.API_style.
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	DefaultAPIStyle string = "openai" // Used when API Service does not define its API style
+	LocalAPIStyle   string = "ollama" // Used when local Service does not define its API style
+	TypeLocal       string = "local"  // Service.Type of models running on the local machine
+)
+
+// Connection details of the Service as needed by the Provider.
+// It is a plain copy of database.Service, so this package does not need to know about the database.
+type Service struct {
+	Name     string
+	APIStyle string
+	Type     string // API or local, see TypeLocal
+	URL      string // Base URL, empty for provider's default
+	Token    Secret // Decrypted only by the Provider when it sends the request, see secret.go
+	// Attempts of all callers are spaced to at most this many per minute, 0 means no limit. See call().
+	RequestsPerMinute int
+}
+
+// Local Services run on our machine, so they do not need the Token.
+func (s Service) IsLocal() bool {
+	return s.Type == TypeLocal
+}
+
+// Sampling parameters sent with every request of the Model. Nil fields are not sent, so the provider's default applies.
+// Providers ignore parameters their API does not know.
+type Sampling struct {
+	Temperature *float64
+	TopP        *float64
+	Seed        *int
+	MaxTokens   *int // Limit of the completion tokens of each request
+}
+
+// Request to describe the JPEG image of the suspect.
+type DescribeRequest struct {
+	Model       string
+	Prompt      string
+	ImageBase64 string // base64 encoded JPEG
+	Sampling    Sampling
+}
+
+// Request to answer the question in two steps: reflection and decision.
+// Prompts are already rendered, providers just send them.
+type AnswerRequest struct {
+	Model            string
+	ReflectionPrompt string   // first user message, asks for reflection on the question
+	DecisionPrompt   string   // last user message, asks for YES or NO based on the reflection
+	ImageBase64      string   // JPEG of the suspect sent along with the reflection prompt, only in vision witness mode
+	Sampling         Sampling // used for both reflection and decision
+	// Ask for the decision as JSON matching DecisionSchema, if the provider supports structured output.
+	// Providers which do not support it ignore this and return plain text, so the caller must parse both.
+	StructuredDecision bool
+	// Called with the pieces of reflection as they arrive, if set. Providers which support streaming stream
+	// the reflection, the others get the whole reflection sent at once by the resilient wrapper.
+	OnReflectionToken func(token string)
+}
+
+// JSON schema of the structured decision: {"answer": "YES"} or {"answer": "NO"}.
+var DecisionSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"answer": {"type": "string", "enum": ["YES", "NO"]}
+	},
+	"required": ["answer"],
+	"additionalProperties": false
+}`)
+
+// Tokens consumed by the call, as reported by the API.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Sum of two Usages, for operations made of more calls.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+	}
+}
+
+// Text generated by the Provider.
+type Completion struct {
+	Text  string
+	Model string // Model identifier as reported back by the API, may differ from the requested alias
+	Usage Usage
+}
+
+// Answer as returned by the Provider.
+type Answer struct {
+	Reflection string
+	Decision   string
+	Usage      Usage // Reflection and decision together
+}
+
+// Provider is a client of one LLM API style.
+type Provider interface {
+	// Describe the image using the visual model.
+	Describe(ctx context.Context, service Service, req DescribeRequest) (Completion, error)
+	// Answer the question, first reflect on it and then decide.
+	Answer(ctx context.Context, service Service, req AnswerRequest) (Answer, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// Register the Provider under the API style. Meant to be called from init() of the provider's file.
+// Panics on duplicate registration, as that is a programming error.
+func Register(apiStyle string, provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if _, exists := providers[apiStyle]; exists {
+		panic(fmt.Sprintf("llm: provider for API style %q registered twice", apiStyle))
+	}
+	providers[apiStyle] = provider
+}
+
+// Get the Provider for the Service based on its API style.
+// Empty API style falls back to LocalAPIStyle for local Services and to DefaultAPIStyle otherwise.
+// Returned Provider has deadlines, retries and circuit breaking applied, see resilience.go.
+func Get(service Service) (Provider, error) {
+	apiStyle := service.APIStyle
+	if apiStyle == "" && service.IsLocal() {
+		apiStyle = LocalAPIStyle
+	}
+	if apiStyle == "" {
+		apiStyle = DefaultAPIStyle
+	}
+
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	provider, ok := providers[apiStyle]
+	if !ok {
+		return nil, fmt.Errorf("no provider for API style %q of service %s", apiStyle, service.Name)
+	}
+	return resilientProvider{provider: provider}, nil
+}
+
+// List API styles of all registered Providers, sorted.
+func APIStyles() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	styles := make([]string, 0, len(providers))
+	for style := range providers {
+		styles = append(styles, style)
+	}
+	sort.Strings(styles)
+	return styles
+}
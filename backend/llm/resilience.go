@@ -0,0 +1,307 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Limits of the calls to the providers. Every Provider returned by Get() is wrapped by them,
+// so the implementations do not need to care.
+var (
+	DescribeTimeout  = 3 * time.Minute  // Deadline of one attempt to describe the image, descriptions are long
+	AnswerTimeout    = 90 * time.Second // Deadline of one attempt to answer, covers both reflection and decision
+	MaxRetries       = 3                // Retries after the first attempt, only on rate limits, 5xx and network errors
+	RetryBaseDelay   = 1 * time.Second  // Backoff doubles on each retry, with jitter
+	BreakerThreshold = 5                // Consecutive failures which open the circuit of the Service
+	BreakerCooldown  = 30 * time.Second // How long the open circuit rejects calls before letting one through
+)
+
+// Returned when the circuit of the Service is open - it failed too many times recently
+// and we do not send it more traffic until BreakerCooldown passes.
+var ErrCircuitOpen = errors.New("service temporarily unavailable")
+
+// Non-OK HTTP response of the provider API.
+type StatusError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s error: status %d", e.Provider, e.StatusCode)
+	}
+	return fmt.Sprintf("%s error (status %d): %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// Error which must not be retried, even if the wrapped one would be.
+type noRetry struct {
+	err error
+}
+
+func (e noRetry) Error() string { return e.err.Error() }
+func (e noRetry) Unwrap() error { return e.err }
+
+// Error is worth retrying: rate limit, server error, timeout of one attempt or network failure.
+// Client errors like wrong token or unknown model are not.
+func retryable(err error) bool {
+	if _, ok := err.(noRetry); ok {
+		return false
+	}
+	var statusErr *StatusError
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	var netErr net.Error
+	status := 0
+	switch {
+	case errors.As(err, &statusErr):
+		status = statusErr.StatusCode
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &netErr):
+		return true
+	}
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// MARK: BREAKER
+
+// Circuit breaker of one Service. Closed circuit lets everything through. After BreakerThreshold
+// consecutive failures it opens and rejects calls with ErrCircuitOpen. Once BreakerCooldown passes,
+// one trial call is let through - success closes the circuit, failure opens it again.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // trial call after cooldown is in flight
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = make(map[string]*breaker)
+)
+
+func breakerFor(service Service) *breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[service.Name]
+	if !ok {
+		b = &breaker{}
+		breakers[service.Name] = b
+	}
+	return b
+}
+
+// Check whether the call can go through. Returns ErrCircuitOpen if not,
+// trial is true when the call is the trial one after cooldown.
+func (b *breaker) allow() (trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < BreakerThreshold {
+		return false, nil
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false, ErrCircuitOpen
+	}
+	b.trial = true
+	return true, nil
+}
+
+// Give up the trial call without a result, e.g. when the caller cancelled it.
+// Nothing is counted, the next call becomes the trial.
+func (b *breaker) abandonTrial() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Record the result of the call. Only failures of the Service itself count,
+// client errors and cancellation by the caller do not.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	if !retryable(err) {
+		return
+	}
+	b.failures++
+	if b.failures >= BreakerThreshold {
+		b.openUntil = time.Now().Add(BreakerCooldown)
+	}
+}
+
+// Check whether the Service accepts calls right now, without making any.
+// Returns wrapped ErrCircuitOpen if its circuit is open.
+func Available(service Service) error {
+	b := breakerFor(service)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures >= BreakerThreshold && (time.Now().Before(b.openUntil) || b.trial) {
+		return fmt.Errorf("%s: %w", service.Name, ErrCircuitOpen)
+	}
+	return nil
+}
+
+// MARK: RATE LIMIT
+
+// Spaces the attempts to one Service by its RequestsPerMinute, shared by all callers.
+type rateLimiter struct {
+	mu   sync.Mutex
+	next time.Time // when the next attempt may start
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[string]*rateLimiter)
+)
+
+func rateLimiterFor(service Service) *rateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	l, ok := rateLimiters[service.Name]
+	if !ok {
+		l = &rateLimiter{}
+		rateLimiters[service.Name] = l
+	}
+	return l
+}
+
+// Wait until the next attempt to the Service may start. Returns ctx.Err() if cancelled while waiting.
+func (l *rateLimiter) wait(ctx context.Context, requestsPerMinute int) error {
+	if requestsPerMinute <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	start := time.Now()
+	if l.next.After(start) {
+		start = l.next
+	}
+	l.next = start.Add(time.Minute / time.Duration(requestsPerMinute))
+	l.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(start)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MARK: RESILIENT PROVIDER
+
+// Provider wrapped with deadlines, retries and the circuit breaker of the Service.
+type resilientProvider struct {
+	provider Provider
+}
+
+func (p resilientProvider) Describe(ctx context.Context, service Service, req DescribeRequest) (Completion, error) {
+	var completion Completion
+	err := call(ctx, service, DescribeTimeout, func(ctx context.Context) error {
+		var err error
+		completion, err = p.provider.Describe(ctx, service, req)
+		return err
+	})
+	return completion, err
+}
+
+// Once some reflection was streamed to the caller, the failed attempt is not retried,
+// as the caller would get the reflection twice.
+func (p resilientProvider) Answer(ctx context.Context, service Service, req AnswerRequest) (Answer, error) {
+	var answer Answer
+	onToken := req.OnReflectionToken
+	streamed := false
+	if onToken != nil {
+		req.OnReflectionToken = func(token string) {
+			streamed = true
+			onToken(token)
+		}
+	}
+	var usage Usage // of all attempts, failed ones can be billed too
+	err := call(ctx, service, AnswerTimeout, func(ctx context.Context) error {
+		var err error
+		answer, err = p.provider.Answer(ctx, service, req)
+		usage = usage.Add(answer.Usage)
+		if err != nil && streamed {
+			return noRetry{err}
+		}
+		return err
+	})
+	answer.Usage = usage
+	if err == nil && onToken != nil && !streamed && answer.Reflection != "" {
+		onToken(answer.Reflection)
+	}
+	return answer, err
+}
+
+// Run the attempt with its own deadline, retry with exponential backoff while the error is retryable.
+// Every attempt, retries included, waits for the rate limit of the Service first.
+func call(ctx context.Context, service Service, timeout time.Duration, attempt func(ctx context.Context) error) error {
+	b := breakerFor(service)
+	limiter := rateLimiterFor(service)
+	trial := false // this call holds the trial of the breaker, which must not stay in flight forever
+	defer func() {
+		if trial {
+			b.abandonTrial()
+		}
+	}()
+	var err error
+	for try := 0; try <= MaxRetries; try++ {
+		if err := limiter.wait(ctx, service.RequestsPerMinute); err != nil {
+			return err
+		}
+		var allowErr error
+		if trial, allowErr = b.allow(); allowErr != nil {
+			return fmt.Errorf("%s: %w", service.Name, allowErr)
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = attempt(attemptCtx)
+		cancel()
+		if ctx.Err() != nil { // cancelled by the caller, not a failure of the Service
+			return ctx.Err()
+		}
+		b.record(err)
+		trial = false
+		if err == nil || !retryable(err) || try == MaxRetries {
+			break
+		}
+
+		delay := RetryBaseDelay << try
+		delay += rand.N(delay/2 + 1)
+		log.Printf("⚠️  Call to %s failed (attempt %d/%d), retrying in %v: %v\n", service.Name, try+1, MaxRetries+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
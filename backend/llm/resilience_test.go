@@ -0,0 +1,119 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Service of its own named after the test, with short breaker limits restored after the test.
+func newBreakerTestService(t *testing.T) Service {
+	threshold, cooldown, maxRetries, baseDelay := BreakerThreshold, BreakerCooldown, MaxRetries, RetryBaseDelay
+	t.Cleanup(func() {
+		BreakerThreshold, BreakerCooldown, MaxRetries, RetryBaseDelay = threshold, cooldown, maxRetries, baseDelay
+	})
+	BreakerThreshold, BreakerCooldown, MaxRetries, RetryBaseDelay = 2, 50*time.Millisecond, 0, time.Millisecond
+	return Service{Name: t.Name()}
+}
+
+func failWithStatus(status int) func(context.Context) error {
+	return func(context.Context) error { return &StatusError{Provider: "Test", StatusCode: status} }
+}
+
+// Open the circuit of the Service and wait out the cooldown, so the next call is the trial.
+func openCircuit(t *testing.T, service Service) {
+	t.Helper()
+	for i := 0; i < BreakerThreshold; i++ {
+		call(context.Background(), service, time.Second, failWithStatus(http.StatusServiceUnavailable))
+	}
+	if err := Available(service); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Available() after %d failures = %v, want ErrCircuitOpen", BreakerThreshold, err)
+	}
+	time.Sleep(2 * BreakerCooldown)
+}
+
+func TestBreakerTrialCancelledByCaller(t *testing.T) {
+	service := newBreakerTestService(t)
+	openCircuit(t, service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := call(ctx, service, time.Second, func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("call() of cancelled trial = %v, want context.Canceled", err)
+	}
+	if err := Available(service); err != nil {
+		t.Fatalf("Available() after cancelled trial = %v, want the next call to be the trial", err)
+	}
+
+	calls := 0
+	err = call(context.Background(), service, time.Second, func(context.Context) error {
+		calls++
+		return nil
+	})
+	if err != nil || calls != 1 {
+		t.Fatalf("call() after cancelled trial = %v with %d attempts, want one successful attempt", err, calls)
+	}
+	if b := breakerFor(service); b.failures != 0 || b.trial {
+		t.Errorf("breaker after successful trial = %d failures, trial %v, want closed", b.failures, b.trial)
+	}
+}
+
+func TestBreakerCancelledDuringBackoff(t *testing.T) {
+	service := newBreakerTestService(t)
+	openCircuit(t, service)
+	MaxRetries, RetryBaseDelay = 1, time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := call(ctx, service, time.Second, failWithStatus(http.StatusTooManyRequests))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("call() cancelled during backoff = %v, want context.DeadlineExceeded", err)
+	}
+	// The failed trial opened the circuit again, after the cooldown it must let the next trial through.
+	time.Sleep(2 * BreakerCooldown)
+	if err := Available(service); err != nil {
+		t.Errorf("Available() after cooldown = %v, want the next call to be the trial", err)
+	}
+}
+
+func TestRateLimitSpacesAttempts(t *testing.T) {
+	service := newBreakerTestService(t)
+	service.RequestsPerMinute = 1200 // one attempt per 50ms
+	BreakerThreshold, MaxRetries = 10, 2
+
+	var starts []time.Time
+	err := call(context.Background(), service, time.Second, func(context.Context) error {
+		starts = append(starts, time.Now())
+		return &StatusError{Provider: "Test", StatusCode: http.StatusTooManyRequests}
+	})
+	if err == nil || len(starts) != 3 {
+		t.Fatalf("call() = %v after %d attempts, want error after first attempt and 2 retries", err, len(starts))
+	}
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 45*time.Millisecond {
+			t.Errorf("attempt %d started %v after the previous one, want at least 50ms", i+1, gap)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := call(ctx, service, time.Second, func(context.Context) error { return nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("call() cancelled while waiting for the rate limit = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2024 (Andreas Gajdosik) <andreas@gajdosik.org>
+// This file is part of project.
+//
+// project is non-violent software: you can use, redistribute,
+// and/or modify it under the terms of the CNPLv7+ as found
+// in the LICENSE file in the source code root directory or
+// at <https://git.pixie.town/thufie/npl-builder>.
+//
+// project comes with ABSOLUTELY NO WARRANTY, to the extent
+// permitted by applicable law. See the CNPL for details.
+
+package llm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// Environment variable with the key for the tokens, any passphrase - AES-256 key is derived from it by SHA-256.
+	TokenKeyEnv     string = "ARTSUS_TOKEN_KEY"
+	secretPrefix    string = "enc:v1:"
+	secretRedacted  string = "[REDACTED]"
+	secretKeyDomain string = "artsus token key v1:"
+)
+
+var ErrNoTokenKey = errors.New(TokenKeyEnv + " is not set")
+
+// API token as stored in the database: encrypted by EncryptToken(), or plaintext from older databases
+// and from the overrides in environment variables or config file.
+// It can be decrypted only inside this package, right before the Provider sends it.
+// Secret never shows its value in JSON, logs or fmt output - non-empty Secret is always "[REDACTED]".
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return secretRedacted
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) IsEmpty() bool {
+	return s == ""
+}
+
+// Token is stored encrypted. Empty and plaintext Secrets are not.
+func (s Secret) Encrypted() bool {
+	return strings.HasPrefix(string(s), secretPrefix)
+}
+
+// Encrypt the plaintext token with the key from TokenKeyEnv, so it can be stored in the database.
+func EncryptToken(plaintext string) (Secret, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	gcm, err := tokenCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return Secret(secretPrefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// Decrypt the token. Plaintext Secret is returned as it is.
+func (s Secret) reveal() (string, error) {
+	if !s.Encrypted() {
+		return string(s), nil
+	}
+	gcm, err := tokenCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(string(s), secretPrefix))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted token")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt token, is %s right? %w", TokenKeyEnv, err)
+	}
+	return string(plaintext), nil
+}
+
+func tokenCipher() (cipher.AEAD, error) {
+	passphrase := os.Getenv(TokenKeyEnv)
+	if passphrase == "" {
+		return nil, ErrNoTokenKey
+	}
+	key := sha256.Sum256([]byte(secretKeyDomain + passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/agajdosi/artificial_suspects/backend/database"
 	"github.com/google/uuid"
@@ -16,9 +21,27 @@ func main() {
 	port := flag.String("port", "8080", "Port to run the server on")
 	host := flag.String("host", "localhost", "Host to run the server on, for production use 0.0.0.0")
 	db_path := flag.String("db-path", "./data/artsus.db", "Path to the database file")
+	answerCache := flag.String("answer-cache", "never", "Reuse generated answers for the same question, description, model and prompts: always, never or probability 0-1")
+	configPath := flag.String("config", "", "Path to JSON config file overriding tokens and URLs of the services")
+	answerSamples := flag.Int("answer-samples", 1, "How many times to ask the model for each answer, the majority decision is returned")
 	flag.Parse()
 
-	err := database.EnsureDBAvailable(*db_path)
+	cachePolicy, err := database.ParseCachePolicy(*answerCache)
+	if err != nil {
+		log.Fatal(err)
+	}
+	database.SetAnswerCachePolicy(cachePolicy)
+	log.Printf("Answer cache policy: %s", cachePolicy)
+	if err := database.SetAnswerSamples(*answerSamples); err != nil {
+		log.Fatal(err)
+	}
+	if *configPath != "" {
+		if err := database.LoadServiceConfig(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = database.EnsureDBAvailable(*db_path)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -36,6 +59,12 @@ func main() {
 	// AI
 	mux.HandleFunc("/get_models", enableCORS(GetModelsHandler))
 	mux.HandleFunc("/get_or_generate_answer", enableCORS(GetOrGenerateAnswerHandler))
+	mux.HandleFunc("/get_answer", enableCORS(GetAnswerHandler))
+	mux.HandleFunc("/stream_answer", enableCORS(StreamAnswerHandler))
+	// stats
+	mux.HandleFunc("/get_answer_stats", enableCORS(database.AnswerStatsHandler))
+	// admin
+	mux.HandleFunc("/admin/get_usage", enableCORS(requireAdmin(GetUsageHandler)))
 	// utils
 	mux.HandleFunc("/status", enableCORS(statusHandler))
 
@@ -52,7 +81,7 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -63,6 +92,47 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// Write the error of the AI Service to the response. Open circuit of the Service and reached
+// spending cap are reported as 503, so the frontend can tell the player to wait or choose another model,
+// other failures as 502.
+func writeAIError(w http.ResponseWriter, err error) {
+	status, msg := aiErrorStatus(err)
+	if errors.Is(err, database.ErrServiceUnavailable) {
+		w.Header().Set("Retry-After", "30")
+	}
+	http.Error(w, msg, status)
+}
+
+// HTTP status and message for the player for the error of the AI Service.
+func aiErrorStatus(err error) (int, string) {
+	if errors.Is(err, database.ErrBudgetExceeded) {
+		return http.StatusServiceUnavailable, "AI model reached its spending cap, choose another model or try again later"
+	}
+	if errors.Is(err, database.ErrServiceUnavailable) {
+		return http.StatusServiceUnavailable, "AI service is temporarily unavailable, try again later"
+	}
+	return http.StatusBadGateway, "AI service failed to answer"
+}
+
+// Admin endpoints are allowed only with Authorization: Bearer <token> matching ARTSUS_ADMIN_TOKEN
+// environment variable. If the variable is not set, admin endpoints are disabled.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminToken := os.Getenv("ARTSUS_ADMIN_TOKEN")
+		if adminToken == "" {
+			log.Printf("Admin endpoint %s requested, but ARTSUS_ADMIN_TOKEN is not set", r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
 func statusHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("🔍 statusHandler() request: %v", r)
 	w.WriteHeader(http.StatusOK)
@@ -81,8 +151,36 @@ func NewGameHandler(w http.ResponseWriter, r *http.Request) {
 	if playerUUID == "" {
 		log.Println("NewGameHandler() warning: player_uuid is empty! Creating new game without player.UUID.")
 	}
+	mode, err := database.ParseWitnessMode(r.URL.Query().Get("witness_mode"))
+	if err != nil {
+		log.Printf("NewGameHandler() error: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	boardSize := database.DefaultBoardSize
+	if size := r.URL.Query().Get("board_size"); size != "" {
+		boardSize, err = strconv.Atoi(size)
+		if err == nil {
+			err = database.CheckBoardSize(boardSize)
+		}
+		if err != nil {
+			log.Printf("NewGameHandler() error: invalid board_size %q: %v", size, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 
-	game, err := database.NewGame(playerUUID, model)
+	game, err := database.NewGame(playerUUID, model, mode, boardSize)
+	if errors.Is(err, database.ErrModelNotVisual) {
+		log.Printf("NewGame() refused: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrBudgetExceeded) {
+		log.Printf("NewGame() refused: %v", err)
+		writeAIError(w, err)
+		return
+	}
 	if err != nil {
 		log.Printf("NewGame() error: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -147,7 +245,7 @@ func NextInvestigationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	game.Investigation, err = database.NewInvestigation(game.UUID)
+	game.Investigation, err = database.NewInvestigation(game.UUID, game.BoardSize)
 	if err != nil {
 		log.Printf("NextInvestigation() error: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -182,6 +280,13 @@ func NextRoundHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check the models before creating the Round, so the Round does not get stuck without an answer.
+	if err := checkModelChainAvailable(game.Model); err != nil {
+		log.Printf("NextRoundHandler() no model available to answer for %s: %v\n", game.Model, err)
+		writeAIError(w, err)
+		return
+	}
+
 	round, err := database.NewRound(game.Investigation.UUID)
 	if err != nil {
 		log.Printf("NextRound() error: %v", err)
@@ -198,25 +303,7 @@ func NextRoundHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	service, err := database.GetServiceForModel(game.Model)
-	if err != nil {
-		log.Printf("NextRoundHandler() could not get service for model %s: %v\n", game.Model, err)
-		return
-	}
-
-	descriptions, err := database.GetDescriptionsForSuspect(
-		game.Investigation.CriminalUUID,
-		game.Model,
-		false, // do not be strict, allow fallback to any description
-	)
-	if err != nil {
-		log.Println("NextRoundHandler() could not get descriptions for suspect")
-		return
-	}
-
-	x := randomForThisInvestigation(game.Investigation.UUID, len(descriptions))
-	go database.GenerateAnswer(round.Question.English, descriptions[x].Description, game.Model, service)
-
+	// Answer itself is generated by /get_or_generate_answer or /stream_answer, which the frontend calls next.
 	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
@@ -320,6 +407,94 @@ func GetModelsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(resp)
 }
 
+// Generate the Answer for the last Round of the game. When the model of the game fails, its Fallbacks are tried
+// in order, Answer.Model then tells which one actually answered. onFallback, if set, is called with the name
+// of the model taking over. Returned Answer has RoundUUID set, but is not saved.
+// In vision witness mode the model sees the portrait of the criminal instead of its Description,
+// fallbacks which are not visual fail and the next one is tried.
+func generateAnswerForGame(game database.Game, onToken func(string), onFallback func(model string)) (database.Answer, error) {
+	round := game.Investigation.Rounds[len(game.Investigation.Rounds)-1]
+	chain, err := database.GetModelChain(game.Model)
+	if err != nil {
+		return database.Answer{}, err
+	}
+
+	for i, model := range chain {
+		if i > 0 {
+			log.Printf("⚠️  Falling back from %s to model %s: %v\n", chain[i-1], model, err)
+			if onFallback != nil {
+				onFallback(model)
+			}
+		}
+
+		var service database.Service
+		service, err = database.GetServiceForModel(model)
+		if err != nil {
+			continue
+		}
+		if err = service.Available(); err != nil {
+			continue
+		}
+
+		var answer database.Answer
+		if game.WitnessMode == database.WitnessVision {
+			var criminal database.Suspect
+			criminal, err = database.GetSuspect(game.Investigation.CriminalUUID)
+			if err != nil {
+				continue
+			}
+			answer, err = database.GenerateVisionAnswerStream(round.Question, criminal, model, service, onToken)
+			if err != nil {
+				continue
+			}
+			answer.RoundUUID = round.UUID
+			return answer, nil
+		}
+
+		var descriptions []database.Description
+		descriptions, err = database.GetDescriptionsForSuspect(
+			game.Investigation.CriminalUUID,
+			model,
+			false, // do not be strict, allow fallback to any description
+		)
+		if err != nil {
+			continue
+		}
+		if len(descriptions) == 0 {
+			err = fmt.Errorf("no descriptions of suspect %s", game.Investigation.CriminalUUID)
+			continue
+		}
+
+		x := randomForThisInvestigation(game.Investigation.UUID, len(descriptions))
+		answer, err = database.GenerateAnswerStream(round.Question, descriptions[x], model, service, onToken)
+		if err != nil {
+			continue
+		}
+		answer.RoundUUID = round.UUID
+		return answer, nil
+	}
+	return database.Answer{}, err
+}
+
+// Check that at least one model of the fallback chain has a Service which is not switched off by the circuit breaker.
+func checkModelChainAvailable(model string) error {
+	chain, err := database.GetModelChain(model)
+	if err != nil {
+		return err
+	}
+	for _, name := range chain {
+		var service database.Service
+		service, err = database.GetServiceForModel(name)
+		if err != nil {
+			continue
+		}
+		if err = service.Available(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
 // TODO: toto muzeme vlastne oddelat
 // 1. generovat answer z newGame anebo z nextRound primo v Gocku
 // 2. na frontend pak jen pockat skrze WaitForAnswer
@@ -332,55 +507,151 @@ func GetOrGenerateAnswerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	game, err := database.GetCurrentGame(playerUUID)
-	question := game.Investigation.Rounds[len(game.Investigation.Rounds)-1].Question.English
 	if err != nil {
 		log.Printf("GetOrGenerateAnswerHandler() could not get currentGame: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	log.Printf("===> game.Model: %s\n", game.Model)
 
-	// TODO: get the service based on the current game's Model
-	service, err := database.GetServiceForModel(game.Model)
+	answer, err := generateAnswerForGame(game, nil, nil)
 	if err != nil {
-		log.Printf("GetOrGenerateAnswerHandler() could not get service for model %s, %v\n", game.Model, err)
+		log.Printf("GetOrGenerateAnswerHandler() error generating answer: %v\n", err)
+		writeAIError(w, err)
 		return
 	}
 
-	descriptions, err := database.GetDescriptionsForSuspect(
-		game.Investigation.CriminalUUID,
-		game.Model,
-		false, // do not be strict, allow fallback to any description
-	)
+	err = database.SaveAnswer(answer)
 	if err != nil {
-		log.Printf("GetOrGenerateAnswerHandler() could not get descriptions for suspect: %v\n", err)
+		log.Printf("GetOrGenerateAnswerHandler() error saving answer: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	x := randomForThisInvestigation(game.Investigation.UUID, len(descriptions))
-	answer, err := database.GenerateAnswer(question, descriptions[x].Description, game.Model, service)
+	log.Printf("GetOrGenerateAnswerHandler() - generated answer: %s", answer.Decision)
+
+	resp, err := json.Marshal(answer)
 	if err != nil {
-		log.Printf("GetOrGenerateAnswerHandler() error generating answer: %v\n", err)
+		log.Printf("GetOrGenerateAnswerHandler() error marshalling answer: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// Same as GetOrGenerateAnswerHandler, but the answer is sent as Server-Sent Events stream:
+// "reflection" events with {"token": "..."} as the witness thinks, then one "decision" event with the whole saved Answer.
+// When the model fails and its fallback takes over, "fallback" event with {"model": "..."} is sent and the reflection starts over.
+// Failure after the stream started is sent as "error" event with {"status": 503, "error": "..."}.
+func StreamAnswerHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("🔍 StreamAnswerHandler() request: %v", r)
+	playerUUID := r.URL.Query().Get("player_uuid")
+	if playerUUID == "" {
+		log.Printf("StreamAnswerHandler() error: query parameter 'player_uuid' cannot be empty!")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Printf("StreamAnswerHandler() error: streaming not supported by the response writer")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// TODO: move to database.GenerateAnswer()?
-	err = database.SaveAnswer(answer, game.Investigation.Rounds[len(game.Investigation.Rounds)-1].UUID)
+	game, err := database.GetCurrentGame(playerUUID)
 	if err != nil {
-		log.Printf("GetOrGenerateAnswerHandler() error saving answer: %v\n", err)
+		log.Printf("StreamAnswerHandler() could not get currentGame: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
 
-	log.Printf("GetOrGenerateAnswerHandler() - generated answer: %s", answer)
+	send := func(event string, data any) {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("StreamAnswerHandler() error marshalling %s event: %v\n", event, err)
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		flusher.Flush()
+	}
 
-	resp, err := json.Marshal(
-		database.Answer{ // TODO: add UUID and Timestamp once Answer has its own table
-			UUID:      "",
-			Text:      answer,
-			Timestamp: "",
-		})
+	answer, err := generateAnswerForGame(game, func(token string) {
+		send("reflection", map[string]string{"token": token})
+	}, func(model string) {
+		send("fallback", map[string]string{"model": model})
+	})
 	if err != nil {
-		log.Printf("GetOrGenerateAnswerHandler() error marshalling answer: %v\n", err)
+		log.Printf("StreamAnswerHandler() error generating answer: %v\n", err)
+		status, msg := aiErrorStatus(err)
+		send("error", map[string]any{"status": status, "error": msg})
+		return
+	}
+
+	err = database.SaveAnswer(answer)
+	if err != nil {
+		log.Printf("StreamAnswerHandler() error saving answer: %v\n", err)
+		send("error", map[string]any{"status": http.StatusInternalServerError, "error": "could not save the answer"})
+		return
+	}
+
+	log.Printf("StreamAnswerHandler() - generated answer: %s", answer.Decision)
+	send("decision", answer)
+}
+
+// Get the Answer with the reflection of the witness for the Round identified by required query parameter round_uuid.
+func GetAnswerHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("🔍 GetAnswerHandler() request: %v", r)
+	roundUUID := r.URL.Query().Get("round_uuid")
+	if roundUUID == "" {
+		log.Printf("GetAnswerHandler() error: query parameter 'round_uuid' cannot be empty!")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	answer, err := database.GetAnswerForRound(roundUUID)
+	if err != nil {
+		log.Printf("GetAnswerForRound() error: %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resp, err := json.Marshal(answer)
+	if err != nil {
+		log.Printf("GetAnswerHandler() error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// Get tokens and cost of the LLM calls, aggregated by query parameter group_by: model (default), game, day or kind.
+func GetUsageHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("🔍 GetUsageHandler() request: %v", r)
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "model"
+	}
+
+	usage, err := database.GetUsageSummary(groupBy)
+	if err != nil {
+		log.Printf("GetUsageSummary() error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := json.Marshal(usage)
+	if err != nil {
+		log.Printf("GetUsageHandler() error: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
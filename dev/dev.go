@@ -12,20 +12,39 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/agajdosi/artificial_suspects/backend/database"
+	"github.com/agajdosi/artificial_suspects/backend/llm"
 	"github.com/urfave/cli/v2"
 )
 
+const (
+	dbPath           = "../backend/data/artsus.db"
+	suspectImagesDir = "../front/static/suspects"
+)
+
 func main() {
 	app := &cli.App{
+		Before: func(cCtx *cli.Context) error {
+			// db commands open the database themselves, so they can show what would be migrated or create a new one
+			if cCtx.Args().First() == "db" {
+				return nil
+			}
+			return database.EnsureDBAvailable(dbPath)
+		},
 		Commands: []*cli.Command{
 			{
 				Name:    "describe",
@@ -47,7 +66,7 @@ func main() {
 			},
 			{
 				Name:  "describe-all",
-				Usage: "Describe the image of specified suspect.",
+				Usage: "Describe images of all suspects. Interrupted run continues where it stopped when started again.",
 				Flags: []cli.Flag{
 					&cli.StringFlag{
 						Name:     "model",
@@ -59,9 +78,278 @@ func main() {
 						Usage:    "Only describe those whose number of descriptions is below the limit",
 						Required: true,
 					},
+					&cli.IntFlag{
+						Name:  "workers",
+						Usage: "How many descriptions to generate in parallel, services limit it further by their concurrency and RPM",
+						Value: 4,
+					},
+					&cli.BoolFlag{
+						Name:  "batch",
+						Usage: "Send the jobs as one OpenAI batch (half the price, results within 24 hours), wait for it and import the results",
+					},
+					&cli.DurationFlag{
+						Name:  "poll-interval",
+						Usage: "How often to check the status of the batch",
+						Value: time.Minute,
+					},
+					&cli.StringFlag{
+						Name:  "batch-file",
+						Usage: "Write the jobs as batch input file (JSONL) to be uploaded by hand instead of sending them",
+					},
+					&cli.StringFlag{
+						Name:  "batch-results",
+						Usage: "Import results file (JSONL) of the batch downloaded by hand, no jobs are queued",
+					},
 				},
 				Action: describeAll,
 			},
+			{
+				Name:   "validate-descriptions",
+				Usage:  "Check stored descriptions for refusals, short texts and wrong language, mark failing ones as rejected.",
+				Action: validateDescriptions,
+			},
+			{
+				Name:  "prompt",
+				Usage: "Manage versions of the prompt templates.",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List versions of the prompts.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "Only list versions of the prompt with this name",
+							},
+							&cli.BoolFlag{
+								Name:  "full",
+								Usage: "Print whole templates",
+							},
+						},
+						Action: listPrompts,
+					},
+					{
+						Name:  "add",
+						Usage: "Add new version of the prompt from the file, new version is not active.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "Name of the prompt: describe, answer_reflection or answer_decision",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "file",
+								Usage:    "Path to the file with the template (text/template syntax)",
+								Required: true,
+							},
+						},
+						Action: addPrompt,
+					},
+					{
+						Name:  "activate",
+						Usage: "Use the version of the prompt for new descriptions and answers.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "Name of the prompt",
+								Required: true,
+							},
+							&cli.IntFlag{
+								Name:     "version",
+								Usage:    "Version of the prompt to activate",
+								Required: true,
+							},
+						},
+						Action: activatePrompt,
+					},
+				},
+			},
+			{
+				Name:  "usage",
+				Usage: "Show tokens and cost of the LLM calls.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "group-by",
+						Usage: "Aggregate by model, game, day or kind",
+						Value: "model",
+					},
+				},
+				Action: usage,
+			},
+			{
+				Name:   "budget",
+				Usage:  "Show spending of the models and services against their caps.",
+				Action: budget,
+			},
+			{
+				Name:  "set-budget",
+				Usage: "Set daily and monthly spending caps in USD of the model or service, 0 means no cap.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "model",
+						Usage: "Name of the model",
+					},
+					&cli.StringFlag{
+						Name:  "service",
+						Usage: "Name of the service, caps all its models together",
+					},
+					&cli.Float64Flag{
+						Name:  "daily",
+						Usage: "Cap for the current day",
+					},
+					&cli.Float64Flag{
+						Name:  "monthly",
+						Usage: "Cap for the current month",
+					},
+				},
+				Action: setBudget,
+			},
+			{
+				Name:  "set-token",
+				Usage: "Store API token of the service encrypted, token is read from stdin. Needs ARTSUS_TOKEN_KEY.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "service",
+						Usage:    "Name of the service",
+						Required: true,
+					},
+				},
+				Action: setToken,
+			},
+			{
+				Name:  "fallbacks",
+				Usage: "Set models which answer instead of the model when it fails, in the given order.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "model",
+						Usage:    "Name of the model",
+						Required: true,
+					},
+					&cli.StringSliceFlag{
+						Name:  "fallback",
+						Usage: "Name of the fallback model, repeat for more. Without any the fallbacks are cleared",
+					},
+				},
+				Action: setFallbacks,
+			},
+			{
+				Name:  "model",
+				Usage: "Set what the model can do, e.g. --visual=false. Unset flags are kept.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "name",
+						Usage:    "Name of the model",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "visual",
+						Usage: "Model can describe images and be the witness in vision mode",
+					},
+					&cli.BoolFlag{
+						Name:  "allowed",
+						Usage: "Model can be used to play the game",
+					},
+					&cli.BoolFlag{
+						Name:  "historical",
+						Usage: "Model is shown in the historical statistics",
+					},
+				},
+				Action: setModel,
+			},
+			{
+				Name:  "price",
+				Usage: "Set prices of the model in USD per 1M tokens, used to compute cost of the calls.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "model",
+						Usage:    "Name of the model",
+						Required: true,
+					},
+					&cli.Float64Flag{
+						Name:  "price",
+						Usage: "Price of any token, used when input and output prices are not set",
+					},
+					&cli.Float64Flag{
+						Name:  "input",
+						Usage: "Price of prompt tokens",
+					},
+					&cli.Float64Flag{
+						Name:  "output",
+						Usage: "Price of completion tokens",
+					},
+				},
+				Action: setPrice,
+			},
+			{
+				Name:  "sampling",
+				Usage: "Set sampling parameters sent with every request of the model. Parameters not given are kept.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "model",
+						Usage:    "Name of the model",
+						Required: true,
+					},
+					&cli.Float64Flag{
+						Name:  "temperature",
+						Usage: "Sampling temperature",
+					},
+					&cli.Float64Flag{
+						Name:  "top-p",
+						Usage: "Nucleus sampling probability mass",
+					},
+					&cli.IntFlag{
+						Name:  "seed",
+						Usage: "Seed for reproducible outputs, where the provider supports it",
+					},
+					&cli.IntFlag{
+						Name:  "max-tokens",
+						Usage: "Limit of completion tokens of each request",
+					},
+					&cli.BoolFlag{
+						Name:  "reset",
+						Usage: "Clear all parameters first, so provider defaults apply",
+					},
+				},
+				Action: setSampling,
+			},
+			{
+				Name:  "db",
+				Usage: "Manage the database.",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "migrate",
+						Usage: "Back up the database and apply pending schema migrations.",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "dry-run",
+								Usage: "Only list the pending migrations",
+							},
+						},
+						Action: migrateDB,
+					},
+					{
+						Name:  "bootstrap",
+						Usage: "Create new database by the migrations and the seeded content, without default.db, and register the images of the suspects which are not seeded.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "output",
+								Usage: "Path of the new database, must not exist",
+								Value: dbPath,
+							},
+							&cli.StringFlag{
+								Name:  "images",
+								Usage: "Directory with the images of the suspects, empty registers only the seeded ones",
+								Value: suspectImagesDir,
+							},
+						},
+						Action: bootstrapDB,
+					},
+					{
+						Name:   "sync",
+						Usage:  "Add suspects, questions, models and descriptions from the embedded default.db which are missing in the database.",
+						Action: syncContent,
+					},
+				},
+			},
 			{
 				Name:    "import",
 				Aliases: []string{"c"},
@@ -71,11 +359,6 @@ func main() {
 		},
 	}
 
-	dbPath := "../backend/data/artsus.db"
-	if err := database.EnsureDBAvailable(dbPath); err != nil {
-		log.Fatal(err)
-	}
-
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
@@ -163,7 +446,454 @@ func describe(cCtx *cli.Context) error {
 }
 
 func describeAll(cCtx *cli.Context) error {
+	if path := cCtx.String("batch-results"); path != "" {
+		return importBatchResults(path)
+	}
+
 	modelName := cCtx.String("model")
 	limit := cCtx.Int("limit")
-	return database.GenerateDescriptionsForAllSuspects(modelName, limit)
+	created, err := database.EnqueueDescribeJobs(modelName, limit)
+	if err != nil {
+		return err
+	}
+	counts, err := database.GetDescribeJobCounts(modelName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Queued %d new jobs, %d pending, %d failed before, %d done before\n",
+		created, counts[database.JobPending]+counts[database.JobRunning], counts[database.JobFailed], counts[database.JobDone])
+	if counts[database.JobBatched] > 0 {
+		fmt.Printf("%d jobs are waiting in batches\n", counts[database.JobBatched])
+	}
+
+	if path := cCtx.String("batch-file"); path != "" {
+		return writeBatchFile(modelName, path)
+	}
+
+	// Ctrl+C stops starting new jobs, the rest is resumed by the next run.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if cCtx.Bool("batch") {
+		return describeAllBatch(ctx, modelName, cCtx.Duration("poll-interval"))
+	}
+
+	progress, err := database.RunDescribeJobs(ctx, modelName, cCtx.Int("workers"), func(job database.DescribeJob, p database.JobProgress) {
+		status := "ok"
+		if job.Status == database.JobFailed {
+			status = "FAILED: " + job.Error
+		}
+		fmt.Printf("[%d/%d] suspect %s: %s\n", p.Done+p.Failed, p.Total, job.SuspectUUID, status)
+	})
+
+	printJobProgress(progress)
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Interrupted, run the same command again to continue.")
+		return nil
+	}
+	return err
+}
+
+// Submit pending jobs as new batch, or pick up batches submitted by an interrupted run, then wait for them and import the results.
+func describeAllBatch(ctx context.Context, modelName string, pollInterval time.Duration) error {
+	batches, err := database.GetUnimportedDescribeBatches(modelName)
+	if err != nil {
+		return err
+	}
+	batch, count, err := database.SubmitDescribeBatch(ctx, modelName)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		fmt.Printf("Submitted batch %s with %d jobs\n", batch.ID, count)
+		batches = append(batches, batch)
+	}
+	if len(batches) == 0 {
+		fmt.Println("No pending jobs to batch")
+		return nil
+	}
+
+	for _, batch := range batches {
+		fmt.Printf("Waiting for batch %s, checking every %s\n", batch.ID, pollInterval)
+		_, err := database.PollDescribeBatch(ctx, batch.ID, pollInterval, func(status llm.BatchStatus) {
+			fmt.Printf("Batch %s: %s, %d/%d completed, %d failed\n", status.ID, status.Status, status.Completed, status.Total, status.Failed)
+		})
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("Interrupted, the batch keeps running. Run the same command again to continue waiting for it.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		progress, err := database.ImportDescribeBatch(ctx, batch.ID)
+		printJobProgress(progress)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBatchFile(modelName, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	batch, count, err := database.WriteDescribeBatchFile(modelName, file)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		fmt.Println("No pending jobs to batch")
+		return os.Remove(path)
+	}
+	fmt.Printf("Wrote %d jobs of batch %s to %s\n", count, batch.ID, path)
+	fmt.Println("Import the results file later with --batch-results.")
+	return nil
+}
+
+func importBatchResults(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	progress, err := database.ImportDescribeBatchResults(file)
+	printJobProgress(progress)
+	return err
+}
+
+func printJobProgress(progress database.JobProgress) {
+	fmt.Printf("\nDone %d, failed %d of %d jobs\n", progress.Done, progress.Failed, progress.Total)
+	if progress.Paused > 0 {
+		fmt.Printf("%d jobs left pending, their service was paused, run the same command again later\n", progress.Paused)
+	}
+	for _, message := range progress.SortedErrors() {
+		fmt.Printf("%5dx %s\n", progress.Errors[message], message)
+	}
+}
+
+func validateDescriptions(cCtx *cli.Context) error {
+	checked, rejected, err := database.ValidateStoredDescriptions()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Checked %d descriptions, rejected %d\n", checked, rejected)
+	return nil
+}
+
+func listPrompts(cCtx *cli.Context) error {
+	prompts, err := database.GetPrompts(cCtx.String("name"))
+	if err != nil {
+		return err
+	}
+	for _, prompt := range prompts {
+		active := ""
+		if prompt.Active {
+			active = " (active)"
+		}
+		fmt.Printf("%s v%d%s - %s - %s\n", prompt.Name, prompt.Version, active, prompt.Timestamp, prompt.UUID)
+		if cCtx.Bool("full") {
+			fmt.Printf("%s\n\n", prompt.Template)
+		}
+	}
+	return nil
+}
+
+func addPrompt(cCtx *cli.Context) error {
+	template, err := os.ReadFile(cCtx.String("file"))
+	if err != nil {
+		return err
+	}
+	prompt, err := database.SavePrompt(cCtx.String("name"), string(template))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s v%d, activate it with: prompt activate --name %s --version %d\n", prompt.Name, prompt.Version, prompt.Name, prompt.Version)
+	return nil
+}
+
+func activatePrompt(cCtx *cli.Context) error {
+	return database.ActivatePrompt(cCtx.String("name"), cCtx.Int("version"))
+}
+
+func usage(cCtx *cli.Context) error {
+	summaries, err := database.GetUsageSummary(cCtx.String("group-by"))
+	if err != nil {
+		return err
+	}
+
+	var total database.UsageSummary
+	fmt.Printf("%-40s %8s %12s %12s %10s\n", cCtx.String("group-by"), "calls", "prompt", "completion", "cost USD")
+	for _, s := range summaries {
+		key := s.Key
+		if key == "" {
+			key = "(none)"
+		}
+		fmt.Printf("%-40s %8d %12d %12d %10.4f\n", key, s.Calls, s.PromptTokens, s.CompletionTokens, s.Cost)
+		total.Calls += s.Calls
+		total.PromptTokens += s.PromptTokens
+		total.CompletionTokens += s.CompletionTokens
+		total.Cost += s.Cost
+	}
+	fmt.Printf("%-40s %8d %12d %12d %10.4f\n", "TOTAL", total.Calls, total.PromptTokens, total.CompletionTokens, total.Cost)
+	return nil
+}
+
+func budget(cCtx *cli.Context) error {
+	statuses, err := database.GetBudgetStatuses()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-8s %-32s %-6s %-11s %10s %10s\n", "scope", "name", "window", "period", "spent USD", "cap USD")
+	for _, s := range statuses {
+		limit := "-"
+		if s.Limit > 0 {
+			limit = fmt.Sprintf("%.2f", s.Limit)
+		}
+		exceeded := ""
+		if s.Exceeded() {
+			exceeded = " EXCEEDED"
+		}
+		fmt.Printf("%-8s %-32s %-6s %-11s %10.4f %10s%s\n", s.Scope, s.Name, s.Window, s.Period, s.Spent, limit, exceeded)
+	}
+	return nil
+}
+
+func setBudget(cCtx *cli.Context) error {
+	var scope, name string
+	var daily, monthly float64
+	switch {
+	case cCtx.IsSet("model") == cCtx.IsSet("service"):
+		return fmt.Errorf("set either --model or --service")
+	case cCtx.IsSet("model"):
+		model, err := database.GetModel(cCtx.String("model"))
+		if err != nil {
+			return err
+		}
+		scope, name, daily, monthly = "model", model.Name, model.DailyBudget, model.MonthlyBudget
+	default:
+		service, err := database.GetService(cCtx.String("service"))
+		if err != nil {
+			return err
+		}
+		scope, name, daily, monthly = "service", service.Name, service.DailyBudget, service.MonthlyBudget
+	}
+	if cCtx.IsSet("daily") {
+		daily = cCtx.Float64("daily")
+	}
+	if cCtx.IsSet("monthly") {
+		monthly = cCtx.Float64("monthly")
+	}
+	if err := database.SetBudget(scope, name, daily, monthly); err != nil {
+		return err
+	}
+	fmt.Printf("Budget of %s %s: daily %.2f USD, monthly %.2f USD (0 means no cap)\n", scope, name, daily, monthly)
+	return nil
+}
+
+func setToken(cCtx *cli.Context) error {
+	fmt.Printf("Paste the token of %s and press Enter: ", cCtx.String("service"))
+	token, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && token == "" {
+		return err
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return fmt.Errorf("token cannot be empty")
+	}
+	if err := database.SetServiceToken(cCtx.String("service"), token); err != nil {
+		return err
+	}
+	fmt.Println("Token stored encrypted.")
+	return nil
+}
+
+func setSampling(cCtx *cli.Context) error {
+	name := cCtx.String("model")
+	model, err := database.GetModel(name)
+	if err != nil {
+		return err
+	}
+	sampling := model.Sampling
+	if cCtx.Bool("reset") {
+		sampling = llm.Sampling{}
+	}
+	if cCtx.IsSet("temperature") {
+		temperature := cCtx.Float64("temperature")
+		sampling.Temperature = &temperature
+	}
+	if cCtx.IsSet("top-p") {
+		topP := cCtx.Float64("top-p")
+		sampling.TopP = &topP
+	}
+	if cCtx.IsSet("seed") {
+		seed := cCtx.Int("seed")
+		sampling.Seed = &seed
+	}
+	if cCtx.IsSet("max-tokens") {
+		maxTokens := cCtx.Int("max-tokens")
+		sampling.MaxTokens = &maxTokens
+	}
+	if err := database.SetModelSampling(name, sampling); err != nil {
+		return err
+	}
+
+	format := func(name string, value any) string {
+		switch v := value.(type) {
+		case *float64:
+			if v != nil {
+				return fmt.Sprintf("%s=%g", name, *v)
+			}
+		case *int:
+			if v != nil {
+				return fmt.Sprintf("%s=%d", name, *v)
+			}
+		}
+		return name + "=default"
+	}
+	fmt.Printf("Sampling of %s: %s %s %s %s\n", name, format("temperature", sampling.Temperature), format("top_p", sampling.TopP),
+		format("seed", sampling.Seed), format("max_tokens", sampling.MaxTokens))
+	return nil
+}
+
+func setPrice(cCtx *cli.Context) error {
+	name := cCtx.String("model")
+	model, err := database.GetModel(name)
+	if err != nil {
+		return err
+	}
+	if cCtx.IsSet("price") {
+		model.Price = cCtx.Float64("price")
+	}
+	if cCtx.IsSet("input") {
+		model.InputPrice = cCtx.Float64("input")
+	}
+	if cCtx.IsSet("output") {
+		model.OutputPrice = cCtx.Float64("output")
+	}
+	if err := database.SetModelPrices(name, model.Price, model.InputPrice, model.OutputPrice); err != nil {
+		return err
+	}
+	fmt.Printf("Prices of %s per 1M tokens: price=%g input=%g output=%g\n", name, model.Price, model.InputPrice, model.OutputPrice)
+	return nil
+}
+
+func setFallbacks(cCtx *cli.Context) error {
+	model := cCtx.String("model")
+	if err := database.SetModelFallbacks(model, cCtx.StringSlice("fallback")); err != nil {
+		return err
+	}
+	chain, err := database.GetModelChain(model)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Answering chain of %s: %s\n", model, strings.Join(chain, " -> "))
+	return nil
+}
+
+func migrateDB(cCtx *cli.Context) error {
+	if err := database.OpenDB(dbPath); err != nil {
+		return err
+	}
+	version, err := database.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	pending, err := database.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Printf("Schema version %d is up to date\n", version)
+		return nil
+	}
+
+	fmt.Printf("Schema version %d, %d pending migrations:\n", version, len(pending))
+	for _, m := range pending {
+		source := m.Source
+		if source == "" {
+			source = "built-in"
+		}
+		fmt.Printf("  %d %s (%s)\n", m.Version, m.Name, source)
+	}
+	if cCtx.Bool("dry-run") {
+		return nil
+	}
+
+	applied, err := database.MigrateDB()
+	if err != nil {
+		return err
+	}
+	version, err = database.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d migrations, schema version is %d\n", len(applied), version)
+	return nil
+}
+
+func bootstrapDB(cCtx *cli.Context) error {
+	result, err := database.BootstrapDB(cCtx.String("output"), cCtx.String("images"))
+	if err != nil {
+		return err
+	}
+	version, err := database.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s at schema version %d with %d questions, %d services, %d models, %d suspects and %d descriptions\n",
+		cCtx.String("output"), version, result.Questions, result.Services, result.Models, result.Suspects, result.Descriptions)
+	fmt.Println("Set the tokens of the services before playing: set-token --service <service>")
+	return nil
+}
+
+func syncContent(cCtx *cli.Context) error {
+	if err := database.OpenDB(dbPath); err != nil {
+		return err
+	}
+	if _, err := database.MigrateDB(); err != nil {
+		return err
+	}
+	report, err := database.SyncContent()
+	if err != nil {
+		return err
+	}
+	for _, table := range report.Tables {
+		fmt.Printf("%-13s added %5d, skipped %5d already present\n", table.Table, table.Added, table.Skipped)
+	}
+	for uuid, reason := range report.Rejected {
+		fmt.Printf("Rejected description %s: %s\n", uuid, reason)
+	}
+	if len(report.Models) > 0 {
+		fmt.Printf("Added models %s\n", strings.Join(report.Models, ", "))
+	}
+	return nil
+}
+
+func setModel(cCtx *cli.Context) error {
+	name := cCtx.String("name")
+	model, err := database.GetModel(name)
+	if err != nil {
+		return err
+	}
+	if cCtx.IsSet("visual") {
+		model.Visual = cCtx.Bool("visual")
+	}
+	if cCtx.IsSet("allowed") {
+		model.Allowed = cCtx.Bool("allowed")
+	}
+	if cCtx.IsSet("historical") {
+		model.Historical = cCtx.Bool("historical")
+	}
+	if err := database.SetModelFlags(name, model.Visual, model.Allowed, model.Historical); err != nil {
+		return err
+	}
+	fmt.Printf("Model %s: visual=%t allowed=%t historical=%t\n", name, model.Visual, model.Allowed, model.Historical)
+	return nil
 }
@@ -1,4 +1,4 @@
-import { currentGame, currentPlayer } from '$lib/stores';
+import { currentGame, currentPlayer, reflection } from '$lib/stores';
 import { get } from 'svelte/store';
 
 // MARK: CONSTANTS
@@ -19,10 +19,48 @@ const initPOST = {
 
 // MARK: TYPES
 
+export type Decision = "yes" | "no" | "unparseable";
+
+// Witness reads descriptions of the portraits, or visual model sees the portraits itself.
+export type WitnessMode = "description" | "vision";
+
+export interface Votes {
+    Yes: number;
+    No: number;
+    Unparseable: number;
+}
+
+// Sampling parameters of the model, null means provider's default.
+export interface Sampling {
+    Temperature: number | null;
+    TopP: number | null;
+    Seed: number | null;
+    MaxTokens: number | null;
+}
+
 export interface Answer {
     UUID: string;
+    RoundUUID: string;
+    QuestionUUID: string;
+    DescriptionUUID: string;
+    SuspectUUID: string; // portrait the witness saw, vision mode only
+    WitnessMode: WitnessMode;
+    CachedFromUUID: string;
+    Service: string;
+    Model: string;
     Text: string;
+    Decision: Decision;
+    RawDecision: string;
+    Samples: number;
+    Votes: Votes;
+    Reflection: string;
+    ReflectionPrompt: string;
+    ReflectionPromptUUID: string;
+    DecisionPrompt: string;
+    DecisionPromptUUID: string;
+    StartTimestamp: string;
     Timestamp: string;
+    Sampling: Sampling;
 }
 
 export interface Elimination {
@@ -58,6 +96,8 @@ export interface Game {
     GameOver: boolean;
     Investigator: string;
     Model: string;
+    WitnessMode: WitnessMode;
+    BoardSize: number; // suspects in each investigation: 12, 15 or 24
     Timestamp: string;
 }
 
@@ -77,6 +117,7 @@ export interface Model {
     Visual: boolean;
     Allowed: boolean;
     Historical: boolean;
+    Sampling: Sampling;
 }
 
 export interface Round {
@@ -85,6 +126,7 @@ export interface Round {
     Question: Question;
     AnswerUUID: string;
     answer: string;
+    AnsweredBy: string; // model which actually answered, differs from Game.Model when fallback was used
     Eliminations: Elimination[];
     Timestamp: string;
 }
@@ -118,12 +160,12 @@ export interface Question {
 
 // MARK: FUNCTIONS
 
-export async function NewGame(model: string): Promise<Game> {
+export async function NewGame(model: string, witnessMode: WitnessMode = "description", boardSize: number = 15): Promise<Game> {
     console.log("NEW GAME requested!");
     let newGame: Game;
     try {
         const player = get(currentPlayer);
-        const response = await fetch(`${API_URL}/new_game?player_uuid=${player.UUID}&model=${model}`, initGET);
+        const response = await fetch(`${API_URL}/new_game?player_uuid=${player.UUID}&model=${model}&witness_mode=${witnessMode}&board_size=${boardSize}`, initGET);
         if (!response.ok) {
             throw new Error('Failed to create new game');
         }
@@ -139,7 +181,7 @@ export async function NewGame(model: string): Promise<Game> {
     if (!lastRoundUUID) {
         throw new Error('Last Round UUID not found in new game');
     }
-    const answer = await generateAnswer(lastRoundUUID);
+    const answer = await streamRoundAnswer(lastRoundUUID);
 
     if (newGame.investigation.rounds.at(-1)) {
         const answerText = answer?.Text;
@@ -183,7 +225,7 @@ export async function NextRound() {
     if (!lastRoundUUID) {
         throw new Error('Last Round UUID not found in new game');
     }
-    const answer = await generateAnswer(lastRoundUUID);
+    const answer = await streamRoundAnswer(lastRoundUUID);
 
     if (game.investigation.rounds.at(-1)) {
         const answerText = answer?.Text;
@@ -272,4 +314,44 @@ export async function generateAnswer(roundUUID: string): Promise<Answer|undefine
         console.error(`generateAnswer error for round ${roundUUID}:`, error);
         // TODO: communicate failure to the user and GUI
     }
-}
\ No newline at end of file
+}
+
+// Stream the answer from /stream_answer, onToken is called with pieces of the witness reflection
+// as they arrive. onFallback is called when the fallback model takes over and the reflection starts over.
+// Resolves with the saved Answer once the decision event comes.
+export function streamAnswer(onToken: (token: string) => void, onFallback?: (model: string) => void): Promise<Answer> {
+    const player = get(currentPlayer);
+    return new Promise((resolve, reject) => {
+        const source = new EventSource(`${API_URL}/stream_answer?player_uuid=${player.UUID}`);
+        source.addEventListener("reflection", (event) => {
+            onToken(JSON.parse((event as MessageEvent).data).token);
+        });
+        source.addEventListener("fallback", (event) => {
+            onFallback?.(JSON.parse((event as MessageEvent).data).model);
+        });
+        source.addEventListener("decision", (event) => {
+            source.close();
+            resolve(JSON.parse((event as MessageEvent).data) as Answer);
+        });
+        source.addEventListener("error", (event) => {
+            source.close();
+            const data = (event as MessageEvent).data;
+            reject(new Error(data ? JSON.parse(data).error : 'Failed to /stream_answer'));
+        });
+    });
+}
+
+// Stream the answer of the round, the reflection of the witness goes to the reflection store as it arrives.
+async function streamRoundAnswer(roundUUID: string): Promise<Answer|undefined> {
+    console.log(`>>> streamRoundAnswer called! roundUUID=${roundUUID}`);
+    reflection.set("");
+    try {
+        return await streamAnswer(
+            (token) => reflection.update((text) => text + token),
+            () => reflection.set(""),
+        );
+    } catch (error) {
+        console.error(`streamRoundAnswer error for round ${roundUUID}:`, error);
+        // TODO: communicate failure to the user and GUI
+    }
+}
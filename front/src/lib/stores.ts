@@ -39,6 +39,9 @@ export const errorMessage = writable<ErrorMessage>(defaultErrorMessage);
 // Hint
 export const hint = writable<string>("");
 
+// Reflection of the witness, streamed while it answers the current question
+export const reflection = writable<string>("");
+
 
 // MARK: Stored PLAYER
 // TODO: actually we can use `import { v4 as uuidv4 } from 'uuid'`;